@@ -78,17 +78,6 @@ export default async function ConfigurationPage() {
                             </pre>
                         </AlertDescription>
                     </Alert>
-
-                    {config.configText && (
-                        <Alert>
-                            <AlertTitle>Current Configuration</AlertTitle>
-                            <AlertDescription>
-                                <pre className="mt-2 p-4 bg-secondary rounded-md overflow-x-auto">
-                                    {config.configText}
-                                </pre>
-                            </AlertDescription>
-                        </Alert>
-                    )}
                 </CardContent>
             </Card>
 
@@ -103,16 +92,16 @@ export default async function ConfigurationPage() {
                 <CardContent>
                     <dl className="space-y-2">
                         <div>
-                            <dt className="font-medium">Active Cluster:</dt>
-                            <dd>{config.activeCluster}</dd>
+                            <dt className="font-medium">Config File:</dt>
+                            <dd>{config.configFile}</dd>
                         </div>
                         <div>
                             <dt className="font-medium">Host:</dt>
-                            <dd>{config.host}</dd>
+                            <dd>{config.host || "Not set"}</dd>
                         </div>
                         <div>
-                            <dt className="font-medium">TLS Enabled:</dt>
-                            <dd>{config.tlsEnabled ? "Yes" : "No"}</dd>
+                            <dt className="font-medium">Seeds:</dt>
+                            <dd>{config.seeds || "Not set"}</dd>
                         </div>
                     </dl>
                 </CardContent>
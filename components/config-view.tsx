@@ -10,12 +10,22 @@ import { Input } from "@/components/ui/input"
 import { Label } from "@/components/ui/label"
 import { Skeleton } from "@/components/ui/skeleton"
 import { Upload, Loader2, Check, X } from "lucide-react"
-import { fetchConfig, updateConfig, uploadTLSFile, type ConfigInfo } from "@/lib/api"
+import {
+  fetchConfig,
+  updateConfig,
+  uploadTLSFile,
+  testConnection,
+  type ConfigInfo,
+  type ConnectionTestResponse,
+} from "@/lib/api"
 
 export function ConfigView() {
   const [config, setConfig] = useState<ConfigInfo | null>(null)
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState<string | null>(null)
+  const [testing, setTesting] = useState(false)
+  const [testResult, setTestResult] = useState<ConnectionTestResponse | null>(null)
+  const [testError, setTestError] = useState<string | null>(null)
 
   useEffect(() => {
     const loadConfig = async () => {
@@ -35,6 +45,20 @@ export function ConfigView() {
     loadConfig()
   }, [])
 
+  const runConnectionTest = async () => {
+    if (!config) return
+    try {
+      setTesting(true)
+      setTestError(null)
+      setTestResult(await testConnection({ host: config.host, seeds: config.seeds }))
+    } catch (err) {
+      setTestError(err instanceof Error ? err.message : "Connection test failed")
+      setTestResult(null)
+    } finally {
+      setTesting(false)
+    }
+  }
+
   if (error) {
     return (
       <Card className="w-full">
@@ -92,6 +116,57 @@ export function ConfigView() {
           </div>
         </div>
 
+        <div className="space-y-2">
+          <div className="flex justify-between items-center">
+            <h3 className="text-sm font-medium">Connection Test</h3>
+            <Button
+              variant="outline"
+              size="sm"
+              onClick={runConnectionTest}
+              disabled={loading || testing || !config || (!config.host && !config.seeds)}
+            >
+              {testing && <Loader2 className="mr-2 h-4 w-4 animate-spin" />}
+              Test connection
+            </Button>
+          </div>
+          {testError && <div className="text-sm text-red-500">{testError}</div>}
+          {testResult && (
+            <div className="rounded-md border p-4 space-y-2">
+              {testResult.stages.map((stage, i) => (
+                <div key={i} className="flex items-start gap-2">
+                  {stage.status === "ok" ? (
+                    <Check className="h-4 w-4 mt-0.5 text-green-500" />
+                  ) : stage.status === "failed" ? (
+                    <X className="h-4 w-4 mt-0.5 text-red-500" />
+                  ) : (
+                    <span className="h-4 w-4 mt-0.5 text-center text-muted-foreground">-</span>
+                  )}
+                  <div className="flex-1 text-sm">
+                    <div className="flex justify-between">
+                      <span className="font-medium uppercase">
+                        {stage.name}
+                        {stage.endpoint && (
+                          <span className="ml-2 normal-case font-mono text-muted-foreground">{stage.endpoint}</span>
+                        )}
+                      </span>
+                      {stage.status !== "skipped" && (
+                        <span className="text-muted-foreground">{stage.latencyMs.toFixed(1)} ms</span>
+                      )}
+                    </div>
+                    <div className="text-muted-foreground">{stage.message}</div>
+                    {stage.certificate && (
+                      <div className="text-xs text-muted-foreground font-mono">
+                        {stage.certificate.subject} issued by {stage.certificate.issuer}, expires in{" "}
+                        {stage.certificate.expiresInDays} days
+                      </div>
+                    )}
+                  </div>
+                </div>
+              ))}
+            </div>
+          )}
+        </div>
+
         <div className="space-y-2">
           <div className="flex justify-between items-center">
             <h3 className="text-sm font-medium">CLI Status</h3>
@@ -114,6 +189,14 @@ export function ConfigView() {
                         : "CLI not installed"}
                     </span>
                   </div>
+                  {config.cliInstalled && !config.cliSupported && (
+                    <div className="text-sm text-amber-600">
+                      This asvec version is not in the supported matrix; the console needs asvec{" "}
+                      {config.minCliVersion} or newer and supports{" "}
+                      {config.supportedVersions.map((v) => `${v.cliMin} to ${v.cliBelow}`).join(", ")} (upper bounds
+                      excluded).
+                    </div>
+                  )}
                   {!config.cliInstalled && (
                     <div className="text-sm text-muted-foreground">
                       Please install the asvec CLI from{" "}
@@ -9,7 +9,7 @@ import { Textarea } from "@/components/ui/textarea"
 import { Tabs, TabsContent, TabsList, TabsTrigger } from "@/components/ui/tabs"
 import { Skeleton } from "@/components/ui/skeleton"
 import { Search, Key, Loader2 } from "lucide-react"
-import { executeQuery, type QueryResult } from "@/lib/api"
+import { executeQuery, type QueryRequest, type QueryResult } from "@/lib/api"
 
 export function QueryView() {
   const [queryType, setQueryType] = useState<"vector" | "key">("vector")
@@ -17,7 +17,6 @@ export function QueryView() {
   const [key, setKey] = useState("doc123")
   const [index, setIndex] = useState("products")
   const [limit, setLimit] = useState("5")
-  const [threshold, setThreshold] = useState("0.7")
 
   const [results, setResults] = useState<QueryResult[]>([])
   const [executionTime, setExecutionTime] = useState<number | null>(null)
@@ -29,20 +28,10 @@ export function QueryView() {
       setLoading(true)
       setError(null)
 
-      const queryData =
+      const queryData: QueryRequest =
         queryType === "vector"
-          ? {
-              type: "vector",
-              vector: JSON.parse(vector),
-              index,
-              limit: Number.parseInt(limit),
-              threshold: Number.parseFloat(threshold),
-            }
-          : {
-              type: "key",
-              key,
-              index,
-            }
+          ? { index, query: JSON.parse(vector), limit: Number.parseInt(limit) }
+          : { index, key, limit: Number.parseInt(limit) }
 
       const response = await executeQuery(queryData)
       setResults(response.results)
@@ -100,17 +89,6 @@ export function QueryView() {
                   <label className="text-sm font-medium">Limit</label>
                   <Input type="number" value={limit} onChange={(e) => setLimit(e.target.value)} />
                 </div>
-                <div className="space-y-2">
-                  <label className="text-sm font-medium">Threshold</label>
-                  <Input
-                    type="number"
-                    value={threshold}
-                    onChange={(e) => setThreshold(e.target.value)}
-                    step="0.1"
-                    min="0"
-                    max="1"
-                  />
-                </div>
               </div>
             </div>
           </TabsContent>
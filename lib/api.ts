@@ -1,26 +1,32 @@
 // API client for interacting with the Go backend
 
 // Types
+// These types mirror the Go structs in server/ and the schemas served at
+// /api/openapi.json.
 export interface Node {
-  id: number
   nodeId: string
   role: string
   endpoint: string
   version: string
-  status: {
-    connected: boolean
-    message: string
-  }
 }
 
-export interface Index {
+export interface IndexInfo {
   name: string
+  namespace: string
+  set: string
+  field: string
   dimensions: number
-  metric: string
+  distanceMetric: string
+  unmerged: number
+  vectorRecords: number
+  size: string
+  unmergedPercent: string
   mode: string
   status: string
-  vectorCount: number
-  unmerged: number
+  vertices: number
+  labels: Record<string, string>
+  storage: string
+  parameters: Record<string, string>
 }
 
 export interface User {
@@ -34,12 +40,12 @@ export interface Role {
 }
 
 export interface ClusterInfo {
-  clusterId: string
+  clusterId?: string
   version: string
   clusterSize: number
   totalVectors: number
   nodeRoles: string[]
-  activeCluster: string
+  activeCluster?: string
 }
 
 export interface QueryResult {
@@ -48,6 +54,21 @@ export interface QueryResult {
   metadata: string
 }
 
+export interface QueryRequest {
+  index: string
+  // One of a query vector, the key of a record whose vector is used, or text
+  // for the server's embedding provider
+  query?: number[]
+  key?: string
+  text?: string
+  set?: string
+  includeSeed?: boolean
+  limit: number
+  ef?: number
+  filter?: Record<string, any>
+  includeBins?: string[]
+}
+
 export interface QueryResponse {
   results: QueryResult[]
   executionTime: number
@@ -55,11 +76,36 @@ export interface QueryResponse {
 
 export interface ConfigInfo {
   configFile: string
-  activeCluster: string
   host: string
-  credentials: string
-  tlsEnabled: boolean
-  configText: string
+  seeds: string
+  cliInstalled: boolean
+  cliVersion: string
+  cliDownloadUrl: string
+  minCliVersion: string
+  supportedVersions: CLISupport[]
+  // False when the installed asvec is outside supportedVersions
+  cliSupported: boolean
+}
+
+// One row of the asvec version matrix; ranges include the lower bound only
+export interface CLISupport {
+  cliMin: string
+  cliBelow: string
+  serverMin: string
+  serverBelow: string
+  format: string
+}
+
+// Error envelope returned by the server for every failed request
+export interface ApiError {
+  code: string
+  message: string
+  details?: string
+  requestId?: string
+}
+
+export interface ApiErrorResponse {
+  error: ApiError
 }
 
 export interface ApiResponse<T> {
@@ -75,7 +121,17 @@ interface FeatureResponse<T> {
 }
 
 // API URL
-const API_BASE_URL = 'http://localhost:8080/api';
+const API_BASE_URL = 'http://localhost:8080/api/v1';
+
+// Helper function to extract a readable message from an error envelope
+async function errorMessage(response: Response): Promise<string> {
+    try {
+        const body: ApiErrorResponse = await response.json();
+        return body.error?.message ?? `HTTP error! status: ${response.status}`;
+    } catch {
+        return `HTTP error! status: ${response.status}`;
+    }
+}
 
 // Helper function to check if server is available
 async function checkServerHealth(): Promise<boolean> {
@@ -121,24 +177,19 @@ export async function fetchNodes(): Promise<Node[]> {
         console.error('Error fetching nodes:', error);
         // Return empty array or mock data as fallback
         return [{
-            id: 1,
             nodeId: "LB",
             role: "N/A",
             endpoint: "127.0.0.1:5555",
             version: "1.1.0-RC",
-            status: {
-                connected: true,
-                message: "Error fetching nodes"
-            }
         }];
     }
 }
 
-export async function fetchIndexes(): Promise<Index[]> {
+export async function fetchIndexes(): Promise<IndexInfo[]> {
   try {
     const response = await fetch(`${API_BASE_URL}/indexes`);
     if (!response.ok) {
-      throw new Error(`HTTP error! status: ${response.status}`);
+      throw new Error(await errorMessage(response));
     }
     return await response.json();
   } catch (error) {
@@ -163,10 +214,14 @@ export async function fetchUsers(): Promise<FeatureResponse<User[]>> {
         const data = JSON.parse(text);
         console.log('Users parsed response:', data);
         
+        if (!response.ok) {
+            const apiError = (data as ApiErrorResponse).error;
+            return { available: false, error: apiError?.code === 'unsupported' ? 'unimplemented' : apiError?.message };
+        }
+        
         return {
             available: data.available ?? false,
             data: data.data,
-            error: data.error
         };
     } catch (error) {
         console.error('Error fetching users:', error);
@@ -190,10 +245,14 @@ export async function fetchRoles(): Promise<FeatureResponse<Role[]>> {
         const data = JSON.parse(text);
         console.log('Roles parsed response:', data);
         
+        if (!response.ok) {
+            const apiError = (data as ApiErrorResponse).error;
+            return { available: false, error: apiError?.code === 'unsupported' ? 'unimplemented' : apiError?.message };
+        }
+        
         return {
             available: data.available ?? false,
             data: data.data,
-            error: data.error
         };
     } catch (error) {
         console.error('Error fetching roles:', error);
@@ -252,7 +311,7 @@ export async function fetchClusterInfo(): Promise<ClusterInfo> {
     }
 }
 
-export async function executeQuery(queryData: any): Promise<QueryResponse> {
+export async function executeQuery(queryData: QueryRequest): Promise<QueryResponse> {
   const response = await fetch(`${API_BASE_URL}/query`, {
     method: "POST",
     headers: {
@@ -262,7 +321,7 @@ export async function executeQuery(queryData: any): Promise<QueryResponse> {
   })
 
   if (!response.ok) {
-    throw new Error(`Failed to execute query: ${response.statusText}`)
+    throw new Error(`Failed to execute query: ${await errorMessage(response)}`)
   }
 
   return response.json()
@@ -312,3 +371,62 @@ export async function uploadTLSFile(
   return response.json()
 }
 
+export interface TLSProfile {
+  caFile?: string
+  certFile?: string
+  keyFile?: string
+  serverName?: string
+  insecureSkipVerify?: boolean
+}
+
+// Host or seeds default to the asvec config file when both are empty
+export interface ConnectionTestRequest {
+  host?: string
+  seeds?: string
+  listenerName?: string
+  tls?: TLSProfile
+  credentials?: { username: string; password: string }
+}
+
+export interface CertificateInfo {
+  subject: string
+  issuer: string
+  dnsNames: string[]
+  ipAddresses: string[]
+  notBefore: string
+  notAfter: string
+  expiresInDays: number
+  tlsVersion: string
+  cipherSuite: string
+  verified: boolean
+  verifyError?: string
+}
+
+export interface ConnectionStage {
+  name: "dns" | "tcp" | "tls" | "auth" | "cluster"
+  endpoint?: string
+  status: "ok" | "failed" | "skipped"
+  latencyMs: number
+  message: string
+  addresses?: string[]
+  certificate?: CertificateInfo
+}
+
+export interface ConnectionTestResponse {
+  host: string
+  seeds: string
+  ok: boolean
+  stages: ConnectionStage[]
+}
+
+export async function testConnection(request: ConnectionTestRequest = {}): Promise<ConnectionTestResponse> {
+  const response = await fetch(`${API_BASE_URL}/config/test`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(request),
+  })
+  if (!response.ok) {
+    throw new Error(await errorMessage(response))
+  }
+  return response.json()
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clusterSubject is the subject of cluster-wide metrics.
+const clusterSubject = "cluster"
+
+// alertMetrics are the metrics alert rules can watch. Index metrics are
+// reported once per index, with the index name as subject.
+var alertMetrics = map[string]string{
+	"node_count":             "number of nodes the cluster reports; 0 when the cluster is unreachable",
+	"mixed_versions":         "1 when nodes run different versions",
+	"index_unmerged_percent": "share of an index's records not merged yet",
+	"index_unmerged":         "number of an index's records not merged yet",
+	"index_not_ready":        "1 when an index's status is not READY",
+}
+
+var alertOperators = map[string]func(value, threshold float64) bool{
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// AlertRule fires when Metric compares with Threshold for at least For.
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	// For is how long the condition must hold before the alert fires, e.g.
+	// "5m". It defaults to firing on the first evaluation.
+	For string `json:"for,omitempty"`
+	// Index limits an index metric to one index.
+	Index    string `json:"index,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// Notifiers names the notifiers to use; all of them when empty.
+	Notifiers []string `json:"notifiers,omitempty"`
+	// RepeatInterval re-sends a firing alert this often, e.g. "4h". A
+	// firing alert is otherwise notified once.
+	RepeatInterval string `json:"repeatInterval,omitempty"`
+}
+
+// AlertConfig holds the alert rules and notifiers.
+type AlertConfig struct {
+	Rules     []AlertRule      `json:"rules"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// Alert is a rule whose condition holds for a subject. It is "pending"
+// until the condition has held for the rule's For duration, then "firing".
+type Alert struct {
+	Rule         string     `json:"rule"`
+	Metric       string     `json:"metric"`
+	Subject      string     `json:"subject"`
+	Severity     string     `json:"severity"`
+	State        string     `json:"state"`
+	Value        float64    `json:"value"`
+	Operator     string     `json:"operator"`
+	Threshold    float64    `json:"threshold"`
+	PendingSince time.Time  `json:"pendingSince"`
+	FiringSince  *time.Time `json:"firingSince,omitempty"`
+	LastNotified *time.Time `json:"lastNotified,omitempty"`
+}
+
+// metricSample is one value of a metric for a subject.
+type metricSample struct {
+	Metric  string
+	Subject string
+	Value   float64
+}
+
+// outgoingNotification is a notification and the notifiers it goes to.
+type outgoingNotification struct {
+	notification AlertNotification
+	notifiers    []string
+}
+
+// alerts evaluates alert rules in the background.
+var alerts *alertManager
+
+// alertManager evaluates the alert rules every interval and notifies on
+// state changes. Rules, notifiers and alert states are saved in dir, so a
+// restart neither loses the configuration nor re-sends firing alerts.
+type alertManager struct {
+	dir      string
+	interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	config    AlertConfig
+	notifiers map[string]notifier
+	states    map[string]*Alert
+}
+
+func newAlertManager(dir string, interval time.Duration) *alertManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &alertManager{
+		dir:       dir,
+		interval:  interval,
+		ctx:       ctx,
+		cancel:    cancel,
+		config:    AlertConfig{Rules: []AlertRule{}, Notifiers: []NotifierConfig{}},
+		notifiers: map[string]notifier{},
+		states:    map[string]*Alert{},
+	}
+}
+
+func (m *alertManager) configPath() string {
+	return filepath.Join(m.dir, "alerts.json")
+}
+
+func (m *alertManager) statePath() string {
+	return filepath.Join(m.dir, "alert-state.json")
+}
+
+// Start loads the saved rules and alert states and starts evaluating.
+func (m *alertManager) Start() error {
+	var config AlertConfig
+	if err := readJSONFile(m.configPath(), &config); err != nil {
+		return err
+	}
+	var states []*Alert
+	if err := readJSONFile(m.statePath(), &states); err != nil {
+		return err
+	}
+
+	notifiers, err := validateAlertConfig(&config)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.config, m.notifiers = config, notifiers
+	for _, state := range states {
+		m.states[alertKey(state.Rule, state.Subject)] = state
+	}
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.runOnce(m.ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends evaluation and waits for notifications in flight.
+func (m *alertManager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// runOnce collects metrics, evaluates the rules and sends notifications.
+func (m *alertManager) runOnce(ctx context.Context) {
+	m.mu.Lock()
+	idle := len(m.config.Rules) == 0
+	m.mu.Unlock()
+	if idle {
+		return
+	}
+	samples, collected := collectAlertMetrics(ctx)
+	for _, out := range m.evaluate(samples, collected, time.Now().UTC()) {
+		m.send(ctx, out)
+	}
+}
+
+// Config returns the rules and notifiers with secrets redacted.
+func (m *alertManager) Config() AlertConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	config := AlertConfig{Rules: m.config.Rules, Notifiers: make([]NotifierConfig, len(m.config.Notifiers))}
+	for i, cfg := range m.config.Notifiers {
+		config.Notifiers[i] = redactNotifier(cfg)
+	}
+	return config
+}
+
+// SetConfig replaces the rules and notifiers. Alerts of rules that no
+// longer exist are dropped without notification.
+func (m *alertManager) SetConfig(config AlertConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, cfg := range config.Notifiers {
+		restored, err := restoreSecrets(cfg, m.config.Notifiers)
+		if err != nil {
+			return newAPIError(ErrCodeBadRequest, "%v", err)
+		}
+		config.Notifiers[i] = restored
+	}
+	notifiers, err := validateAlertConfig(&config)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONFile(m.configPath(), config); err != nil {
+		return err
+	}
+	m.config, m.notifiers = config, notifiers
+
+	rules := map[string]bool{}
+	for _, rule := range config.Rules {
+		rules[rule.Name] = true
+	}
+	for key, state := range m.states {
+		if !rules[state.Rule] {
+			delete(m.states, key)
+		}
+	}
+	return m.saveStatesLocked()
+}
+
+// Alerts lists pending and firing alerts, firing first.
+func (m *alertManager) Alerts() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Alert, 0, len(m.states))
+	for _, state := range m.states {
+		list = append(list, *state)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].State != list[j].State {
+			return list[i].State == "firing"
+		}
+		return alertKey(list[i].Rule, list[i].Subject) < alertKey(list[j].Rule, list[j].Subject)
+	})
+	return list
+}
+
+// Test sends a test notification through one notifier.
+func (m *alertManager) Test(ctx context.Context, name string) error {
+	m.mu.Lock()
+	n, ok := m.notifiers[name]
+	m.mu.Unlock()
+	if !ok {
+		return newAPIError(ErrCodeNotFound, "notifier %q not found", name)
+	}
+	now := time.Now().UTC()
+	err := n.Notify(ctx, AlertNotification{
+		Status:   "firing",
+		Rule:     "test",
+		Subject:  clusterSubject,
+		Severity: SeverityInfo,
+		StartsAt: now,
+		Message:  "Test notification from the AVS console",
+	})
+	if err != nil {
+		return &APIError{Code: ErrCodeBackend, Message: fmt.Sprintf("notifier %s failed", name), Details: err.Error()}
+	}
+	return nil
+}
+
+// evaluate advances the alert states with a round of samples and returns
+// the notifications to send. Subjects missing from a metric that was
+// collected resolve; metrics that could not be collected leave their
+// alerts as they are.
+func (m *alertManager) evaluate(samples []metricSample, collected map[string]bool, now time.Time) []outgoingNotification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []outgoingNotification
+	seen := map[string]bool{}
+	for _, rule := range m.config.Rules {
+		holdFor, _ := parseOptionalDuration(rule.For)
+		repeat, _ := parseOptionalDuration(rule.RepeatInterval)
+		for _, sample := range samples {
+			if sample.Metric != rule.Metric || (rule.Index != "" && sample.Subject != rule.Index) {
+				continue
+			}
+			key := alertKey(rule.Name, sample.Subject)
+			state := m.states[key]
+			if !alertOperators[rule.Operator](sample.Value, rule.Threshold) {
+				continue
+			}
+			seen[key] = true
+			if state == nil {
+				state = &Alert{
+					Rule:         rule.Name,
+					Metric:       rule.Metric,
+					Subject:      sample.Subject,
+					Severity:     rule.Severity,
+					State:        "pending",
+					Operator:     rule.Operator,
+					Threshold:    rule.Threshold,
+					PendingSince: now,
+				}
+				m.states[key] = state
+			}
+			state.Value = sample.Value
+
+			switch {
+			case state.State == "pending" && now.Sub(state.PendingSince) >= holdFor:
+				state.State = "firing"
+				state.FiringSince = &now
+			case state.State == "firing" && repeat > 0 && state.LastNotified != nil && now.Sub(*state.LastNotified) >= repeat:
+				// Still firing past the repeat interval; notify again
+			default:
+				continue
+			}
+			state.LastNotified = &now
+			out = append(out, outgoingNotification{notification: alertNotification(*state, "firing", nil), notifiers: rule.Notifiers})
+		}
+	}
+
+	for key, state := range m.states {
+		if seen[key] || !collected[state.Metric] {
+			continue
+		}
+		delete(m.states, key)
+		if state.State != "firing" {
+			continue
+		}
+		var notifiers []string
+		for _, rule := range m.config.Rules {
+			if rule.Name == state.Rule {
+				notifiers = rule.Notifiers
+			}
+		}
+		out = append(out, outgoingNotification{notification: alertNotification(*state, "resolved", &now), notifiers: notifiers})
+	}
+
+	if err := m.saveStatesLocked(); err != nil {
+		logger.Printf("Error saving alert state: %v", err)
+	}
+	return out
+}
+
+// send delivers a notification to its notifiers, logging failures.
+func (m *alertManager) send(ctx context.Context, out outgoingNotification) {
+	m.mu.Lock()
+	targets := map[string]notifier{}
+	for name, n := range m.notifiers {
+		targets[name] = n
+	}
+	m.mu.Unlock()
+
+	names := out.notifiers
+	if len(names) == 0 {
+		for name := range targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		n, ok := targets[name]
+		if !ok {
+			continue
+		}
+		if err := n.Notify(ctx, out.notification); err != nil {
+			logger.Printf("Error sending %s alert %s to %s: %v", out.notification.Status, out.notification.Rule, name, err)
+			continue
+		}
+		logger.Printf("Sent %s alert %s for %s to %s", out.notification.Status, out.notification.Rule, out.notification.Subject, name)
+	}
+}
+
+func (m *alertManager) saveStatesLocked() error {
+	states := make([]*Alert, 0, len(m.states))
+	for _, state := range m.states {
+		states = append(states, state)
+	}
+	return writeJSONFile(m.statePath(), states)
+}
+
+func alertKey(rule, subject string) string {
+	return rule + "\x00" + subject
+}
+
+func alertNotification(state Alert, status string, endsAt *time.Time) AlertNotification {
+	startsAt := state.PendingSince
+	if state.FiringSince != nil {
+		startsAt = *state.FiringSince
+	}
+	return AlertNotification{
+		Status:    status,
+		Rule:      state.Rule,
+		Metric:    state.Metric,
+		Subject:   state.Subject,
+		Severity:  state.Severity,
+		Value:     state.Value,
+		Operator:  state.Operator,
+		Threshold: state.Threshold,
+		StartsAt:  startsAt,
+		EndsAt:    endsAt,
+		Message:   fmt.Sprintf("%s is %g (%s %g)", state.Metric, state.Value, state.Operator, state.Threshold),
+	}
+}
+
+func parseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("must not be negative")
+	}
+	return d, err
+}
+
+// validateAlertConfig checks rules and notifiers, fills in defaults and
+// builds the notifiers.
+func validateAlertConfig(config *AlertConfig) (map[string]notifier, error) {
+	if config.Rules == nil {
+		config.Rules = []AlertRule{}
+	}
+	if config.Notifiers == nil {
+		config.Notifiers = []NotifierConfig{}
+	}
+
+	notifiers := map[string]notifier{}
+	for _, cfg := range config.Notifiers {
+		if cfg.Name == "" {
+			return nil, newAPIError(ErrCodeBadRequest, "every notifier needs a name")
+		}
+		if _, ok := notifiers[cfg.Name]; ok {
+			return nil, newAPIError(ErrCodeBadRequest, "notifier %q is defined more than once", cfg.Name)
+		}
+		n, err := newNotifier(cfg)
+		if err != nil {
+			return nil, newAPIError(ErrCodeBadRequest, "%v", err)
+		}
+		notifiers[cfg.Name] = n
+	}
+
+	names := map[string]bool{}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Name == "" || names[rule.Name] {
+			return nil, newAPIError(ErrCodeBadRequest, "rule %d needs a unique name", i+1)
+		}
+		names[rule.Name] = true
+		if strings.ContainsAny(rule.Name, "\r\n") {
+			// Rule names go into the Subject header of alert emails
+			return nil, newAPIError(ErrCodeBadRequest, "rule %d has a line break in its name", i+1)
+		}
+		if _, ok := alertMetrics[rule.Metric]; !ok {
+			return nil, newAPIError(ErrCodeBadRequest, "rule %s watches unknown metric %q (want one of %s)",
+				rule.Name, rule.Metric, strings.Join(sortedKeys(alertMetrics), ", "))
+		}
+		if _, ok := alertOperators[rule.Operator]; !ok {
+			return nil, newAPIError(ErrCodeBadRequest, "rule %s has unknown operator %q", rule.Name, rule.Operator)
+		}
+		if _, err := parseOptionalDuration(rule.For); err != nil {
+			return nil, newAPIError(ErrCodeBadRequest, "rule %s has an invalid for duration: %v", rule.Name, err)
+		}
+		if _, err := parseOptionalDuration(rule.RepeatInterval); err != nil {
+			return nil, newAPIError(ErrCodeBadRequest, "rule %s has an invalid repeat interval: %v", rule.Name, err)
+		}
+		if rule.Severity == "" {
+			rule.Severity = SeverityWarning
+		}
+		if _, ok := severityRank[rule.Severity]; !ok {
+			return nil, newAPIError(ErrCodeBadRequest, "rule %s has unknown severity %q", rule.Name, rule.Severity)
+		}
+		for _, name := range rule.Notifiers {
+			if _, ok := notifiers[name]; !ok {
+				return nil, newAPIError(ErrCodeBadRequest, "rule %s uses unknown notifier %q", rule.Name, name)
+			}
+		}
+	}
+	return notifiers, nil
+}
+
+// collectAlertMetrics samples the cluster and index metrics. collected
+// names the metrics that could be sampled this round. An unreachable
+// cluster reports no nodes.
+func collectAlertMetrics(ctx context.Context) ([]metricSample, map[string]bool) {
+	collected := map[string]bool{"node_count": true}
+	nodes, err := listNodes(ctx)
+	if err != nil {
+		logger.Printf("Alert metrics: node list failed: %v", err)
+		return []metricSample{{Metric: "node_count", Subject: clusterSubject}}, collected
+	}
+
+	versions := map[string]bool{}
+	for _, node := range nodes {
+		if node.Version != "" {
+			versions[node.Version] = true
+		}
+	}
+	mixed := 0.0
+	if len(versions) > 1 {
+		mixed = 1
+	}
+	samples := []metricSample{
+		{Metric: "node_count", Subject: clusterSubject, Value: float64(len(nodes))},
+		{Metric: "mixed_versions", Subject: clusterSubject, Value: mixed},
+	}
+	collected["mixed_versions"] = true
+
+	indexes, err := listIndexes(ctx)
+	if err != nil {
+		logger.Printf("Alert metrics: index list failed: %v", err)
+		return samples, collected
+	}
+	for _, index := range indexes {
+		notReady := 0.0
+		if !strings.EqualFold(index.Status, "READY") {
+			notReady = 1
+		}
+		samples = append(samples,
+			metricSample{Metric: "index_unmerged_percent", Subject: index.Name, Value: unmergedPercent(index)},
+			metricSample{Metric: "index_unmerged", Subject: index.Name, Value: float64(index.Unmerged)},
+			metricSample{Metric: "index_not_ready", Subject: index.Name, Value: notReady},
+		)
+	}
+	for _, metric := range []string{"index_unmerged_percent", "index_unmerged", "index_not_ready"} {
+		collected[metric] = true
+	}
+	return samples, collected
+}
+
+func getAlerts(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts.Alerts())
+}
+
+func getAlertConfig(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts.Config())
+}
+
+// putAlertConfig replaces the alert rules and notifiers. Secrets read back
+// as "********" keep their saved values.
+func putAlertConfig(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling alert configuration request from %s", r.RemoteAddr)
+
+	var config AlertConfig
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	if err := alerts.SetConfig(config); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts.Config())
+}
+
+// testNotifier sends a test notification through a configured notifier.
+func testNotifier(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling notifier test request from %s", r.RemoteAddr)
+
+	if err := alerts.Test(r.Context(), r.PathValue("name")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
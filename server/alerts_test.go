@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureServer is a local HTTP stand-in for webhook and Slack endpoints.
+func captureServer(t *testing.T) (*httptest.Server, <-chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+// fakeSMTP is a minimal local SMTP stand-in that records the DATA of each
+// message it accepts. With a TLS config it offers STARTTLS and only accepts
+// mail once the connection is encrypted, as most submission servers do.
+type fakeSMTP struct {
+	addr     string
+	tls      *tls.Config
+	mu       sync.Mutex
+	messages []string
+}
+
+func startFakeSMTP(t *testing.T, tlsConfig *tls.Config) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSMTP{addr: ln.Addr().String(), tls: tlsConfig}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost fake SMTP")
+	secure := false
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO") && s.tls != nil && !secure:
+			reply("250-localhost")
+			reply("250 STARTTLS")
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "STARTTLS" && s.tls != nil:
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, in, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case strings.HasPrefix(cmd, "MAIL") && s.tls != nil && !secure:
+			reply("530 must issue STARTTLS first")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := in.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func testNotification() AlertNotification {
+	return AlertNotification{
+		Status: "firing", Rule: "nodes-down", Metric: "node_count", Subject: clusterSubject,
+		Severity: SeverityCritical, Value: 2, Operator: "<", Threshold: 3,
+		StartsAt: time.Now().UTC(), Message: "node_count is 2 (< 3)",
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	srv, bodies := captureServer(t)
+	n, err := newNotifier(NotifierConfig{Name: "hook", Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	var got AlertNotification
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Rule != "nodes-down" || got.Status != "firing" || got.Value != 2 {
+		t.Errorf("webhook received %+v", got)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	srv, bodies := captureServer(t)
+	n, err := newNotifier(NotifierConfig{Name: "slack", Type: "slack", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got["text"], "[FIRING] nodes-down") {
+		t.Errorf("slack text = %q", got["text"])
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	// httptest's certificate is valid for 127.0.0.1, which the notifier
+	// must check it against
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	certServer.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certServer.Certificate())
+
+	for _, tt := range []struct {
+		name string
+		tls  *tls.Config
+	}{
+		{"plain", nil},
+		{"starttls", &tls.Config{Certificates: certServer.TLS.Certificates}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startFakeSMTP(t, tt.tls)
+			n, err := newNotifier(NotifierConfig{Name: "mail", Type: "smtp", Host: server.addr, From: "avs@example.com", To: []string{"ops@example.com"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.(*smtpNotifier).rootCAs = roots
+			if err := n.Notify(context.Background(), testNotification()); err != nil {
+				t.Fatal(err)
+			}
+			messages := server.received()
+			if len(messages) != 1 || !strings.Contains(messages[0], "Subject: [FIRING] nodes-down: cluster") {
+				t.Errorf("smtp received %q", messages)
+			}
+		})
+	}
+}
+
+// TestAlertLifecycle checks the for duration, de-duplication and
+// resolution of an alert.
+func TestAlertLifecycle(t *testing.T) {
+	m := newAlertManager(t.TempDir(), time.Hour)
+	srv, bodies := captureServer(t)
+	err := m.SetConfig(AlertConfig{
+		Rules:     []AlertRule{{Name: "nodes-down", Metric: "node_count", Operator: "<", Threshold: 3, For: "1m"}},
+		Notifiers: []NotifierConfig{{Name: "hook", Type: "webhook", URL: srv.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collected := map[string]bool{"node_count": true}
+	nodes := func(n float64) []metricSample {
+		return []metricSample{{Metric: "node_count", Subject: clusterSubject, Value: n}}
+	}
+	start := time.Now()
+	steps := []struct {
+		after time.Duration
+		nodes float64
+		want  string
+	}{
+		{0, 2, ""},                       // pending
+		{30 * time.Second, 2, ""},        // still within for
+		{time.Minute, 2, "firing"},       // fires once
+		{2 * time.Minute, 2, ""},         // de-duplicated
+		{3 * time.Minute, 3, "resolved"}, // condition cleared
+		{4 * time.Minute, 3, ""},         // nothing to resolve
+	}
+	for _, step := range steps {
+		out := m.evaluate(nodes(step.nodes), collected, start.Add(step.after))
+		got := ""
+		for _, o := range out {
+			m.send(context.Background(), o)
+			got = o.notification.Status
+			<-bodies
+		}
+		if got != step.want {
+			t.Fatalf("after %s with %g nodes: notified %q, want %q", step.after, step.nodes, got, step.want)
+		}
+	}
+	if alerts := m.Alerts(); len(alerts) != 0 {
+		t.Errorf("alerts left after resolution: %+v", alerts)
+	}
+}
+
+func TestAlertConfigSecretsRoundTrip(t *testing.T) {
+	m := newAlertManager(t.TempDir(), time.Hour)
+	hook := NotifierConfig{Name: "hook", Type: "slack", URL: "https://hooks.slack.com/services/T0/B0/secret"}
+	if err := m.SetConfig(AlertConfig{Notifiers: []NotifierConfig{hook}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The URL is hidden from GET and kept when sent back unchanged
+	config := m.Config()
+	if got := config.Notifiers[0].URL; got != redacted {
+		t.Fatalf("url = %q", got)
+	}
+	if err := m.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.config.Notifiers[0].URL; got != hook.URL {
+		t.Errorf("url = %q, want %q", got, hook.URL)
+	}
+
+	// A new notifier cannot be given a redacted URL
+	renamed := NotifierConfig{Name: "other", Type: "slack", URL: redacted}
+	if err := m.SetConfig(AlertConfig{Notifiers: []NotifierConfig{renamed}}); err == nil || asAPIError(err).Code != ErrCodeBadRequest {
+		t.Errorf("err = %v, want bad request", err)
+	}
+}
+
+func TestAlertRuleNameLineBreak(t *testing.T) {
+	config := AlertConfig{Rules: []AlertRule{{Name: "nodes\r\nBcc: someone@example.com", Metric: "node_count", Operator: "<", Threshold: 3}}}
+	if _, err := validateAlertConfig(&config); err == nil || asAPIError(err).Code != ErrCodeBadRequest {
+		t.Errorf("err = %v, want bad request", err)
+	}
+}
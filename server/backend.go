@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// asvec runs every asvec subprocess started by the handlers.
+var asvec *backend
+
+// backend bounds the number of concurrent asvec subprocesses, applies
+// per-operation timeouts and coalesces identical concurrent calls so that,
+// for example, several dashboard tabs polling /api/nodes share one
+// `asvec node ls`.
+type backend struct {
+	sem chan struct{}
+	// batch holds the slots of searches issued in bulk by benchmarks and
+	// batch queries, which are kept apart from sem so that they cannot
+	// starve interactive calls.
+	batch chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]*backendCall
+}
+
+// backendCall is a single asvec invocation shared by every request that asked
+// for the same command while it was running.
+type backendCall struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	output []byte
+	err    error
+
+	// waiters counts requests still interested in the result. The call is
+	// cancelled when the last one goes away.
+	waiters int
+}
+
+func newBackend(maxCalls, maxBatchCalls int) *backend {
+	return &backend{
+		sem:      make(chan struct{}, maxCalls),
+		batch:    make(chan struct{}, maxBatchCalls),
+		inflight: make(map[string]*backendCall),
+	}
+}
+
+// killWaitDelay bounds how long a killed asvec call is waited for. Without
+// it, a child process still holding the output pipes open would keep the
+// call running after its context is done.
+const killWaitDelay = time.Second
+
+// Run executes `asvec args...` and returns its stdout. Identical concurrent
+// calls are coalesced, so Run must only be used for read-only commands. op
+// names the operation for timeout lookup and logging.
+func (b *backend) Run(ctx context.Context, op string, args ...string) ([]byte, error) {
+	key := strings.Join(args, "\x00")
+
+	b.mu.Lock()
+	call, ok := b.inflight[key]
+	if ok {
+		call.waiters++
+		logger.Printf("Coalescing %s request onto in-flight asvec call", op)
+	} else {
+		// The shared call must not die with the first request that started
+		// it, so it runs on a detached context cancelled by the last waiter.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &backendCall{done: make(chan struct{}), cancel: cancel, waiters: 1}
+		b.inflight[key] = call
+		go func() {
+			call.output, _, call.err = b.exec(callCtx, b.sem, op, args, nil)
+			b.mu.Lock()
+			if b.inflight[key] == call {
+				delete(b.inflight, key)
+			}
+			b.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.output, call.err
+	case <-ctx.Done():
+		b.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if b.inflight[key] == call {
+				delete(b.inflight, key)
+			}
+		}
+		b.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Exec executes `asvec args...` without coalescing. It is meant for commands
+// with side effects.
+func (b *backend) Exec(ctx context.Context, op string, args ...string) ([]byte, error) {
+	output, _, err := b.exec(ctx, b.sem, op, args, nil)
+	return output, err
+}
+
+// ExecBatch is Exec for searches issued in bulk. It runs on its own slots,
+// bounded by AVS_CONSOLE_MAX_BATCH_CALLS, and also returns how long the
+// command ran, which leaves out the wait for a slot.
+func (b *backend) ExecBatch(ctx context.Context, op string, args ...string) ([]byte, time.Duration, error) {
+	return b.exec(ctx, b.batch, op, args, nil)
+}
+
+// ExecEnv is Exec with extra environment variables, for settings such as
+// credentials that must not appear in the logged command line.
+func (b *backend) ExecEnv(ctx context.Context, op string, env []string, args ...string) ([]byte, error) {
+	output, _, err := b.exec(ctx, b.sem, op, args, env)
+	return output, err
+}
+
+// exec runs asvec on one of slots and returns its stdout with the time the
+// command ran.
+func (b *backend) exec(ctx context.Context, slots chan struct{}, op string, args, env []string) ([]byte, time.Duration, error) {
+	timeout := serverConfig.timeoutFor(op)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return nil, 0, fmt.Errorf("waiting for a free asvec slot for %s: %w", op, ctx.Err())
+	}
+
+	cmd := exec.CommandContext(ctx, "asvec", args...)
+	cmd.WaitDelay = killWaitDelay
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	logger.Printf("Executing command: %s", commandLine(args))
+
+	start := time.Now()
+	output, err := cmd.Output()
+	ran := time.Since(start)
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, ran, fmt.Errorf("asvec %s timed out after %s: %w", op, timeout, ctx.Err())
+	}
+	return output, ran, err
+}
+
+// maxLoggedArg bounds how much of each argument is logged, so that query
+// vectors do not fill the log.
+const maxLoggedArg = 256
+
+// commandLine formats an asvec command for the log, shortening long
+// arguments.
+func commandLine(args []string) string {
+	line := []string{"asvec"}
+	for _, arg := range args {
+		if len(arg) > maxLoggedArg {
+			arg = fmt.Sprintf("%s...(%d bytes)", arg[:maxLoggedArg], len(arg))
+		}
+		line = append(line, arg)
+	}
+	return strings.Join(line, " ")
+}
+
+// logStderr logs the stderr captured from a failed asvec command, if any.
+func logStderr(err error) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		logger.Printf("Command stderr: %s", string(exitErr.Stderr))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowAsvec installs an asvec that appends its arguments to the returned
+// file, sleeps for its first argument in seconds and prints "done".
+func slowAsvec(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\nsleep $1\necho done\n"
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func TestBackendCoalescesIdenticalReads(t *testing.T) {
+	calls := slowAsvec(t)
+	b := newBackend(4, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := b.Run(context.Background(), "test", "0.3", "same")
+			if err != nil || string(output) != "done\n" {
+				t.Errorf("output = %q, err = %v", output, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := b.Run(context.Background(), "test", "0", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := os.ReadFile(calls); string(output) != "0.3 same\n0 other\n" {
+		t.Errorf("calls = %q", output)
+	}
+}
+
+func TestBackendCallOutlivesFirstWaiter(t *testing.T) {
+	slowAsvec(t)
+	b := newBackend(4, 1)
+
+	first, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := b.Run(first, "test", "0.3")
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	second := make(chan []byte, 1)
+	go func() {
+		output, _ := b.Run(context.Background(), "test", "0.3")
+		second <- output
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("first waiter err = %v", err)
+	}
+	if output := <-second; string(output) != "done\n" {
+		t.Errorf("second waiter output = %q", output)
+	}
+}
+
+func TestBackendCancelStopsCall(t *testing.T) {
+	slowAsvec(t)
+	b := newBackend(1, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := b.Exec(ctx, "test", "5"); err == nil {
+		t.Fatal("cancelled call succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call took %s after cancel", elapsed)
+	}
+	// The slot is given back
+	if _, err := b.Exec(context.Background(), "test", "0"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackendTimeoutsAndSlots(t *testing.T) {
+	slowAsvec(t)
+	defer func(cfg ServerConfig) { serverConfig = cfg }(serverConfig)
+	serverConfig.DefaultTimeout = 5 * time.Second
+	serverConfig.Timeouts = map[string]time.Duration{"quick": 100 * time.Millisecond}
+	b := newBackend(1, 1)
+
+	_, err := b.Exec(context.Background(), "quick", "5")
+	if !errors.Is(err, context.DeadlineExceeded) || asAPIError(backendError("run", err)).Code != ErrCodeTimeout {
+		t.Errorf("err = %v", err)
+	}
+
+	// A call waiting for a busy slot gives up at its timeout
+	b.sem <- struct{}{}
+	_, err = b.Exec(context.Background(), "quick", "0")
+	if err == nil || !strings.Contains(err.Error(), "waiting for a free asvec slot") {
+		t.Errorf("err = %v", err)
+	}
+	// Batch searches run on their own slots, and their time leaves out
+	// the wait for one
+	b.batch <- struct{}{}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		<-b.batch
+	}()
+	output, took, err := b.ExecBatch(context.Background(), "test", "0")
+	if err != nil || string(output) != "done\n" || took >= 300*time.Millisecond {
+		t.Errorf("output = %q, took = %s, err = %v", output, took, err)
+	}
+	<-b.sem
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStoredBenchmarks bounds how many benchmark results are kept.
+const maxStoredBenchmarks = 100
+
+// maxBenchmarkDuration bounds how long a single benchmark may run.
+const maxBenchmarkDuration = time.Hour
+
+// maxBenchmarkRate bounds the searches per second of a fixed-rate run.
+const maxBenchmarkRate = 10000
+
+// maxBenchmarkErrors bounds the distinct error messages kept per run.
+const maxBenchmarkErrors = 20
+
+// histogramBoundsMs are the upper bounds of the latency histogram buckets.
+var histogramBoundsMs = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+// BenchmarkRequest configures a benchmark run.
+type BenchmarkRequest struct {
+	Index string `json:"index"`
+	// Name labels the run when comparing results.
+	Name string `json:"name,omitempty"`
+	// Queries are replayed in order. asvec cannot read records, so they are
+	// not sampled from the index and must be given.
+	Queries [][]float64 `json:"queries"`
+	// Limit is the number of results per search; Ef overrides the index's
+	// HNSW ef.
+	Limit int `json:"limit,omitempty"`
+	Ef    int `json:"ef,omitempty"`
+	// Concurrency is the number of workers issuing searches back to back.
+	// It is bounded by AVS_CONSOLE_MAX_BATCH_CALLS, the slots benchmark
+	// searches run on apart from interactive calls.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Rate, when set, issues searches at a fixed number per second
+	// regardless of how long they take. Concurrency then bounds the
+	// searches in flight and searches that find no free slot are dropped.
+	// It must allow at least one search in Duration.
+	Rate float64 `json:"rate,omitempty"`
+	// Duration is how long to run, e.g. "30s".
+	Duration string `json:"duration,omitempty"`
+}
+
+// LatencyStats summarises a set of search latencies.
+type LatencyStats struct {
+	MeanMs float64 `json:"meanMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P95Ms  float64 `json:"p95Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+// HistogramBucket counts latencies up to UpperMs. The last bucket has no
+// upper bound.
+type HistogramBucket struct {
+	UpperMs float64 `json:"upperMs,omitempty"`
+	Count   int     `json:"count"`
+}
+
+// BenchmarkResult is the outcome of a benchmark run.
+type BenchmarkResult struct {
+	ID         string            `json:"id"`
+	JobID      string            `json:"jobId,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Index      string            `json:"index"`
+	Parameters map[string]string `json:"parameters"`
+	// Mode is "concurrency" for closed-loop runs or "rate" for fixed-rate
+	// runs.
+	Mode            string  `json:"mode"`
+	Concurrency     int     `json:"concurrency"`
+	Rate            float64 `json:"rate,omitempty"`
+	Limit           int     `json:"limit"`
+	Ef              int     `json:"ef,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Queries         int     `json:"queries"`
+	Requests        int     `json:"requests"`
+	Succeeded       int     `json:"succeeded"`
+	Failed          int     `json:"failed"`
+	// Dropped counts fixed-rate searches skipped because every slot was
+	// busy, a sign that the target rate is not sustainable.
+	Dropped   int     `json:"dropped"`
+	ErrorRate float64 `json:"errorRate"`
+	// Throughput is successful searches per second.
+	Throughput float64           `json:"throughput"`
+	Latency    LatencyStats      `json:"latency"`
+	Histogram  []HistogramBucket `json:"histogram"`
+	// Errors counts failed searches by error message.
+	Errors     map[string]int `json:"errors"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+}
+
+// BenchmarkDelta is the change of a run relative to the baseline, in
+// percent for latencies and throughput and in points for the error rate.
+type BenchmarkDelta struct {
+	ID              string  `json:"id"`
+	P50Percent      float64 `json:"p50Percent"`
+	P95Percent      float64 `json:"p95Percent"`
+	P99Percent      float64 `json:"p99Percent"`
+	ThroughputPct   float64 `json:"throughputPercent"`
+	ErrorRateChange float64 `json:"errorRateChange"`
+}
+
+// BenchmarkComparison compares runs with the first one.
+type BenchmarkComparison struct {
+	Baseline string            `json:"baseline"`
+	Runs     []BenchmarkResult `json:"runs"`
+	Deltas   []BenchmarkDelta  `json:"deltas"`
+}
+
+// benchmarks keeps finished benchmark results.
+var benchmarks *benchmarkStore
+
+// benchmarkStore persists benchmark results to a JSON file, newest last.
+type benchmarkStore struct {
+	path    string
+	mu      sync.Mutex
+	results []BenchmarkResult
+}
+
+func newBenchmarkStore(dir string) *benchmarkStore {
+	store := &benchmarkStore{path: filepath.Join(dir, "benchmarks.json")}
+	if err := readJSONFile(store.path, &store.results); err != nil {
+		logger.Printf("Error loading benchmark results: %v", err)
+	}
+	return store
+}
+
+func (s *benchmarkStore) Add(result BenchmarkResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, result)
+	if len(s.results) > maxStoredBenchmarks {
+		s.results = s.results[len(s.results)-maxStoredBenchmarks:]
+	}
+	return writeJSONFile(s.path, s.results)
+}
+
+func (s *benchmarkStore) Get(id string) (BenchmarkResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, result := range s.results {
+		if result.ID == id {
+			return result, nil
+		}
+	}
+	return BenchmarkResult{}, newAPIError(ErrCodeNotFound, "benchmark %q not found", id)
+}
+
+// List returns the results for an index, or all results, newest first.
+func (s *benchmarkStore) List(index string) []BenchmarkResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []BenchmarkResult{}
+	for i := len(s.results) - 1; i >= 0; i-- {
+		if index == "" || s.results[i].Index == index {
+			list = append(list, s.results[i])
+		}
+	}
+	return list
+}
+
+func (s *benchmarkStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, result := range s.results {
+		if result.ID == id {
+			s.results = append(s.results[:i], s.results[i+1:]...)
+			return writeJSONFile(s.path, s.results)
+		}
+	}
+	return newAPIError(ErrCodeNotFound, "benchmark %q not found", id)
+}
+
+func normalizeBenchmarkRequest(req *BenchmarkRequest) (time.Duration, error) {
+	if req.Index == "" {
+		return 0, newAPIError(ErrCodeBadRequest, "index is required")
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = 1
+	}
+	if req.Duration == "" {
+		req.Duration = "30s"
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	switch {
+	case err != nil || duration <= 0 || duration > maxBenchmarkDuration:
+		return 0, newAPIError(ErrCodeBadRequest, "duration must be a positive duration of at most %s", maxBenchmarkDuration)
+	case len(req.Queries) == 0:
+		return 0, newAPIError(ErrCodeBadRequest, "queries are required")
+	case req.Limit < 1 || req.Ef < 0:
+		return 0, newAPIError(ErrCodeBadRequest, "limit must be positive and ef must not be negative")
+	case req.Rate < 0 || req.Rate > maxBenchmarkRate:
+		return 0, newAPIError(ErrCodeBadRequest, "rate must be between 0 and %d searches per second", maxBenchmarkRate)
+	case req.Rate > 0 && req.Rate*duration.Seconds() < 1:
+		// Slower rates would not search at all, and the interval between
+		// searches would overflow for the slowest
+		return 0, newAPIError(ErrCodeBadRequest, "rate must allow at least one search in %s", duration)
+	case req.Concurrency < 1 || req.Concurrency > serverConfig.MaxBatchCalls:
+		// More workers than backend slots would measure time spent queueing
+		// for a slot rather than search latency
+		return 0, newAPIError(ErrCodeBadRequest, "concurrency must be between 1 and %d (AVS_CONSOLE_MAX_BATCH_CALLS)", serverConfig.MaxBatchCalls)
+	}
+	return duration, nil
+}
+
+// benchmarkRecorder collects the outcome of every search in a run.
+type benchmarkRecorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	failed    int
+	dropped   int
+	errors    map[string]int
+}
+
+func (b *benchmarkRecorder) record(elapsed time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.latencies = append(b.latencies, elapsed)
+		return
+	}
+	b.failed++
+	message := asAPIError(err).Error()
+	if _, ok := b.errors[message]; ok || len(b.errors) < maxBenchmarkErrors {
+		b.errors[message]++
+	}
+}
+
+func (b *benchmarkRecorder) requests() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.latencies) + b.failed
+}
+
+// runBenchmark replays query vectors against an index for the requested
+// duration. progress, if not nil, receives the searches issued so far and
+// the fraction of the duration elapsed.
+func runBenchmark(ctx context.Context, index IndexInfo, req BenchmarkRequest, duration time.Duration, progress func(processed int64, fraction float64)) (BenchmarkResult, error) {
+	result := BenchmarkResult{
+		ID:          newRequestID(),
+		Name:        req.Name,
+		Index:       index.Name,
+		Parameters:  index.Parameters,
+		Mode:        "concurrency",
+		Concurrency: req.Concurrency,
+		Rate:        req.Rate,
+		Limit:       req.Limit,
+		Ef:          req.Ef,
+	}
+	if result.Parameters == nil {
+		result.Parameters = map[string]string{}
+	}
+	if req.Rate > 0 {
+		result.Mode = "rate"
+	}
+
+	queries := req.Queries
+	for _, query := range queries {
+		if err := checkDimensions(index, query); err != nil {
+			return result, err
+		}
+	}
+	result.Queries = len(queries)
+
+	runCtx, stop := context.WithTimeout(ctx, duration)
+	defer stop()
+	rec := &benchmarkRecorder{errors: map[string]int{}}
+	var next int
+	var nextMu sync.Mutex
+	search := func() {
+		nextMu.Lock()
+		query := queries[next%len(queries)]
+		next++
+		nextMu.Unlock()
+
+		_, elapsed, err := runSearch(runCtx, asvec.ExecBatch, index.Name, searchQuery{Vector: query}, req.Limit, req.Ef)
+		// Searches cut short by the end of the run are not failures
+		if runCtx.Err() != nil {
+			return
+		}
+		rec.record(elapsed, err)
+	}
+
+	result.StartedAt = time.Now()
+	var wg sync.WaitGroup
+	if req.Rate > 0 {
+		slots := make(chan struct{}, req.Concurrency)
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / req.Rate))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-runCtx.Done():
+					return
+				case <-ticker.C:
+				}
+				select {
+				case slots <- struct{}{}:
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						defer func() { <-slots }()
+						search()
+					}()
+				default:
+					rec.mu.Lock()
+					rec.dropped++
+					rec.mu.Unlock()
+				}
+			}
+		}()
+	} else {
+		for i := 0; i < req.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for runCtx.Err() == nil {
+					search()
+				}
+			}()
+		}
+	}
+
+	if progress != nil {
+		ticker := time.NewTicker(time.Second)
+	report:
+		for {
+			select {
+			case <-runCtx.Done():
+				break report
+			case <-ticker.C:
+				progress(int64(rec.requests()), time.Since(result.StartedAt).Seconds()/duration.Seconds())
+			}
+		}
+		ticker.Stop()
+	}
+	wg.Wait()
+	result.FinishedAt = time.Now()
+
+	// A cancelled run is not a result
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	elapsed := result.FinishedAt.Sub(result.StartedAt).Seconds()
+	result.DurationSeconds = elapsed
+	result.Succeeded = len(rec.latencies)
+	result.Failed = rec.failed
+	result.Dropped = rec.dropped
+	result.Requests = result.Succeeded + result.Failed
+	result.Errors = rec.errors
+	if result.Requests > 0 {
+		result.ErrorRate = float64(result.Failed) / float64(result.Requests)
+	}
+	if elapsed > 0 {
+		result.Throughput = float64(result.Succeeded) / elapsed
+	}
+	result.Histogram = latencyHistogram(rec.latencies)
+	result.Latency = latencyStats(rec.latencies)
+
+	logger.Printf("Benchmark of %s finished: %d searches, %.1f qps, p99 %.1fms, %d failed",
+		index.Name, result.Requests, result.Throughput, result.Latency.P99Ms, result.Failed)
+	return result, nil
+}
+
+// latencyHistogram counts latencies into the histogramBoundsMs buckets.
+func latencyHistogram(latencies []time.Duration) []HistogramBucket {
+	buckets := make([]HistogramBucket, len(histogramBoundsMs)+1)
+	for i, bound := range histogramBoundsMs {
+		buckets[i].UpperMs = bound
+	}
+	for _, latency := range latencies {
+		ms := milliseconds(latency)
+		i := sort.SearchFloat64s(histogramBoundsMs, ms)
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// compareBenchmarks reports how each run differs from the first.
+func compareBenchmarks(runs []BenchmarkResult) BenchmarkComparison {
+	comparison := BenchmarkComparison{Baseline: runs[0].ID, Runs: runs, Deltas: []BenchmarkDelta{}}
+	base := runs[0]
+	change := func(value, baseline float64) float64 {
+		if baseline == 0 {
+			return 0
+		}
+		return math.Round((value-baseline)/baseline*10000) / 100
+	}
+	for _, run := range runs[1:] {
+		comparison.Deltas = append(comparison.Deltas, BenchmarkDelta{
+			ID:              run.ID,
+			P50Percent:      change(run.Latency.P50Ms, base.Latency.P50Ms),
+			P95Percent:      change(run.Latency.P95Ms, base.Latency.P95Ms),
+			P99Percent:      change(run.Latency.P99Ms, base.Latency.P99Ms),
+			ThroughputPct:   change(run.Throughput, base.Throughput),
+			ErrorRateChange: run.ErrorRate - base.ErrorRate,
+		})
+	}
+	return comparison
+}
+
+// submitBenchmark queues a benchmark job.
+func submitBenchmark(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling benchmark request from %s", r.RemoteAddr)
+
+	var req BenchmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	params, err := encodeJobParams(req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	job, err := jobs.Submit("benchmark", params)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJob(w, http.StatusAccepted, job)
+}
+
+// listBenchmarks lists stored results, optionally for one ?index=.
+func listBenchmarks(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(benchmarks.List(r.URL.Query().Get("index")))
+}
+
+func getBenchmark(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	result, err := benchmarks.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func deleteBenchmark(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if err := benchmarks.Delete(r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBenchmarkComparison compares the runs named in ?ids=, the first being
+// the baseline.
+func getBenchmarkComparison(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	var runs []BenchmarkResult
+	for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		result, err := benchmarks.Get(id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		runs = append(runs, result)
+	}
+	if len(runs) < 2 {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "ids must name at least two benchmarks"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(compareBenchmarks(runs))
+}
+
+func validateBenchmarkJob(params map[string]interface{}) error {
+	var req BenchmarkRequest
+	if err := decodeJobParams(params, &req); err != nil {
+		return err
+	}
+	_, err := normalizeBenchmarkRequest(&req)
+	return err
+}
+
+// runBenchmarkJob runs a benchmark and stores its result.
+func runBenchmarkJob(ctx context.Context, job *jobHandle) (interface{}, error) {
+	var req BenchmarkRequest
+	if err := decodeJobParams(job.Params, &req); err != nil {
+		return nil, err
+	}
+	duration, err := normalizeBenchmarkRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+	index, err := findIndex(ctx, req.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Rate > 0 {
+		job.Logf("Benchmarking %s at %.1f searches/s for %s", index.Name, req.Rate, duration)
+	} else {
+		job.Logf("Benchmarking %s with %d workers for %s", index.Name, req.Concurrency, duration)
+	}
+	result, err := runBenchmark(ctx, index, req, duration, job.Progress)
+	if err != nil {
+		return nil, err
+	}
+	result.JobID = job.ID
+	if err := benchmarks.Add(result); err != nil {
+		return nil, &APIError{Code: ErrCodeInternal, Message: "failed to store benchmark result", Details: err.Error()}
+	}
+	job.Logf("%d searches, %.1f/s, p50 %.1fms, p95 %.1fms, p99 %.1fms, error rate %.2f%%",
+		result.Requests, result.Throughput, result.Latency.P50Ms, result.Latency.P95Ms, result.Latency.P99Ms, result.ErrorRate*100)
+	return result, nil
+}
+
+// latencyStats summarises latencies; the slice is sorted in place.
+func latencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(latencies)))) - 1
+		return milliseconds(latencies[max(i, 0)])
+	}
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	return LatencyStats{
+		MeanMs: milliseconds(total / time.Duration(len(latencies))),
+		P50Ms:  percentile(0.50),
+		P95Ms:  percentile(0.95),
+		P99Ms:  percentile(0.99),
+		MaxMs:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeBenchmarkRequestRate(t *testing.T) {
+	defer func(calls int) { serverConfig.MaxBatchCalls = calls }(serverConfig.MaxBatchCalls)
+	serverConfig.MaxBatchCalls = 4
+	tests := []struct {
+		rate     float64
+		duration string
+		ok       bool
+	}{
+		{0, "30s", true},
+		{0.5, "2s", true},
+		{0.5, "1s", false},
+		{1e-10, "1h", false},
+		{maxBenchmarkRate, "1s", true},
+		{maxBenchmarkRate + 1, "1s", false},
+		{-1, "1s", false},
+	}
+	for _, tt := range tests {
+		req := BenchmarkRequest{Index: "idx", Queries: [][]float64{{1}}, Rate: tt.rate, Duration: tt.duration}
+		duration, err := normalizeBenchmarkRequest(&req)
+		if (err == nil) != tt.ok {
+			t.Errorf("rate %g for %s: err = %v", tt.rate, tt.duration, err)
+		}
+		if err == nil && tt.rate > 0 {
+			// The ticker interval must be positive and within the run
+			if interval := time.Duration(float64(time.Second) / tt.rate); interval <= 0 || interval > duration {
+				t.Errorf("rate %g for %s: interval %s", tt.rate, tt.duration, interval)
+			}
+		}
+	}
+}
+
+func TestNormalizeBenchmarkRequestNeedsQueries(t *testing.T) {
+	req := BenchmarkRequest{Index: "idx"}
+	if _, err := normalizeBenchmarkRequest(&req); err == nil || asAPIError(err).Code != ErrCodeBadRequest {
+		t.Errorf("err = %v, want bad request", err)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	ms := func(values ...float64) []time.Duration {
+		var latencies []time.Duration
+		for _, v := range values {
+			latencies = append(latencies, time.Duration(v*float64(time.Millisecond)))
+		}
+		return latencies
+	}
+	buckets := latencyHistogram(ms(0.2, 1, 1.5, 2, 7, 10000, 10001, 60000))
+	if len(buckets) != len(histogramBoundsMs)+1 {
+		t.Fatalf("buckets = %+v", buckets)
+	}
+	counts := map[float64]int{}
+	for _, bucket := range buckets {
+		counts[bucket.UpperMs] += bucket.Count
+	}
+	// Bounds are inclusive and the unbounded last bucket has UpperMs 0
+	want := map[float64]int{1: 2, 2: 2, 10: 1, 10000: 1, 0: 2}
+	for _, bound := range histogramBoundsMs {
+		if _, ok := want[bound]; !ok {
+			want[bound] = 0
+		}
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+	if buckets := latencyHistogram(nil); buckets[len(buckets)-1].UpperMs != 0 || buckets[0].Count != 0 {
+		t.Errorf("empty histogram = %+v", buckets)
+	}
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	run := func(id string, p50, throughput, errorRate float64) BenchmarkResult {
+		return BenchmarkResult{ID: id, Latency: LatencyStats{P50Ms: p50, P95Ms: p50 * 2, P99Ms: p50 * 3}, Throughput: throughput, ErrorRate: errorRate}
+	}
+	comparison := compareBenchmarks([]BenchmarkResult{run("a", 10, 100, 0.1), run("b", 15, 50, 0.3), run("c", 10, 0, 0)})
+	if comparison.Baseline != "a" || len(comparison.Deltas) != 2 {
+		t.Fatalf("comparison = %+v", comparison)
+	}
+	if got := comparison.Deltas[0]; got.ID != "b" || got.P50Percent != 50 || got.P99Percent != 50 || got.ThroughputPct != -50 || got.ErrorRateChange < 0.19 || got.ErrorRateChange > 0.21 {
+		t.Errorf("delta = %+v", got)
+	}
+	if got := compareBenchmarks([]BenchmarkResult{run("a", 0, 0, 0), run("b", 5, 10, 0)}).Deltas[0]; got.P50Percent != 0 || got.ThroughputPct != 0 {
+		t.Errorf("delta from a zero baseline = %+v", got)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	if got := latencyStats(nil); got != (LatencyStats{}) {
+		t.Errorf("empty stats = %+v", got)
+	}
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	want := LatencyStats{MeanMs: 50.5, P50Ms: 50, P95Ms: 95, P99Ms: 99, MaxMs: 100}
+	if got := latencyStats(latencies); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+	if got := latencyStats([]time.Duration{3 * time.Millisecond}); got.P50Ms != 3 || got.P99Ms != 3 {
+		t.Errorf("single stats = %+v", got)
+	}
+}
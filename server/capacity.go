@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CapacityModel holds the per-record overheads the estimator adds to the
+// vectors and metadata. An HNSW node keeps its vector plus 2*m neighbour
+// links on the base layer and, on average, m/(m-1) links on the upper
+// layers. Only the primary index entry is a documented Aerospike size; the
+// rest approximate AVS internals, so the model used is returned with every
+// estimate, a request can replace it, and calibrate checks it against the
+// cluster's indexes.
+type CapacityModel struct {
+	// LinkBytes is the size of one HNSW neighbour link.
+	LinkBytes int64 `json:"linkBytes"`
+	// IndexEntryBytes is the index memory per vector besides its vector and
+	// links.
+	IndexEntryBytes int64 `json:"indexEntryBytes"`
+	// PrimaryIndexBytes is Aerospike's primary index entry per record.
+	PrimaryIndexBytes int64 `json:"primaryIndexBytes"`
+	// RecordHeaderBytes is the storage per record besides its bins.
+	RecordHeaderBytes int64 `json:"recordHeaderBytes"`
+}
+
+// defaultCapacityModel is the model used when a request does not give one.
+var defaultCapacityModel = CapacityModel{LinkBytes: 8, IndexEntryBytes: 64, PrimaryIndexBytes: 64, RecordHeaderBytes: 48}
+
+const (
+	defaultHNSWM       = 16
+	defaultReplication = 2
+	// defaultElementType is what calibrate assumes for indexes the request
+	// does not name, since asvec does not list an index's element type.
+	defaultElementType = "float32"
+)
+
+// elementSizes are the bytes per vector element for each element type.
+var elementSizes = map[string]int{
+	"float32": 4,
+	"float64": 8,
+	"float16": 2,
+	"int8":    1,
+	"uint8":   1,
+	"bool":    1,
+}
+
+// CapacityRequest describes a planned index.
+type CapacityRequest struct {
+	Dimensions int   `json:"dimensions"`
+	Records    int64 `json:"records"`
+	// M is the HNSW max links per node; it defaults to 16.
+	M int `json:"m,omitempty"`
+	// ElementType is float32 (the default), float64, float16, int8, uint8
+	// or bool.
+	ElementType string `json:"elementType,omitempty"`
+	// MetadataBytes is the average size of the other bins of a record.
+	MetadataBytes int64 `json:"metadataBytes,omitempty"`
+	// ReplicationFactor is the copies kept of each record and index entry;
+	// it defaults to 2.
+	ReplicationFactor int `json:"replicationFactor,omitempty"`
+	// Model replaces the default overheads; each of its fields is used as
+	// given.
+	Model *CapacityModel `json:"model,omitempty"`
+	// CalibrationElementTypes names the element type of existing indexes
+	// for calibration. Indexes it does not name are assumed float32.
+	CalibrationElementTypes map[string]string `json:"calibrationElementTypes,omitempty"`
+}
+
+// CapacityEstimate is the projected footprint of an index. Totals are for
+// one copy; per-node figures spread all copies over the cluster's nodes.
+type CapacityEstimate struct {
+	Request            CapacityRequest `json:"request"`
+	IndexMemoryBytes   int64           `json:"indexMemoryBytes"`
+	RecordStorageBytes int64           `json:"recordStorageBytes"`
+	Nodes              int             `json:"nodes"`
+	PerNodeIndexBytes  int64           `json:"perNodeIndexBytes"`
+	PerNodeRecordBytes int64           `json:"perNodeRecordBytes"`
+	// Calibration compares the estimator with existing indexes. When it
+	// has data, CalibrationFactor is the median ratio of actual to
+	// estimated size and CalibratedIndexBytes applies it.
+	Calibration          []CapacityCheck `json:"calibration"`
+	CalibrationFactor    float64         `json:"calibrationFactor,omitempty"`
+	CalibratedIndexBytes int64           `json:"calibratedIndexBytes,omitempty"`
+}
+
+// CapacityCheck compares the estimate for an existing index with the size
+// the cluster reports for it.
+type CapacityCheck struct {
+	Index         string `json:"index"`
+	Dimensions    int    `json:"dimensions"`
+	VectorRecords int    `json:"vectorRecords"`
+	M             int    `json:"m"`
+	ElementType   string `json:"elementType"`
+	// ElementTypeAssumed is true when the request did not name the index's
+	// element type and float32 was assumed.
+	ElementTypeAssumed bool    `json:"elementTypeAssumed"`
+	ActualBytes        int64   `json:"actualBytes"`
+	EstimatedBytes     int64   `json:"estimatedBytes"`
+	ErrorPercent       float64 `json:"errorPercent"`
+}
+
+// normalizeCapacityRequest fills in defaults and rejects impossible sizes.
+func normalizeCapacityRequest(req *CapacityRequest) error {
+	if req.Dimensions < 1 || req.Records < 0 {
+		return newAPIError(ErrCodeBadRequest, "dimensions must be positive and records must not be negative")
+	}
+	if req.M == 0 {
+		req.M = defaultHNSWM
+	}
+	if req.M < 2 {
+		return newAPIError(ErrCodeBadRequest, "m must be at least 2")
+	}
+	if req.ElementType == "" {
+		req.ElementType = defaultElementType
+	}
+	req.ElementType = strings.ToLower(req.ElementType)
+	if _, ok := elementSizes[req.ElementType]; !ok {
+		return newAPIError(ErrCodeBadRequest, "unknown element type %q", req.ElementType)
+	}
+	if req.ReplicationFactor == 0 {
+		req.ReplicationFactor = defaultReplication
+	}
+	if req.ReplicationFactor < 1 || req.MetadataBytes < 0 {
+		return newAPIError(ErrCodeBadRequest, "replicationFactor must be positive and metadataBytes must not be negative")
+	}
+	if req.Model == nil {
+		model := defaultCapacityModel
+		req.Model = &model
+	}
+	if m := req.Model; m.LinkBytes < 0 || m.IndexEntryBytes < 0 || m.PrimaryIndexBytes < 0 || m.RecordHeaderBytes < 0 {
+		return newAPIError(ErrCodeBadRequest, "model sizes must not be negative")
+	}
+	for index, elementType := range req.CalibrationElementTypes {
+		elementType = strings.ToLower(elementType)
+		if _, ok := elementSizes[elementType]; !ok {
+			return newAPIError(ErrCodeBadRequest, "unknown element type %q for index %q", elementType, index)
+		}
+		req.CalibrationElementTypes[index] = elementType
+	}
+	return nil
+}
+
+// indexBytesPerRecord is the estimated index memory for one vector.
+func indexBytesPerRecord(model CapacityModel, dimensions, m int, elementType string) float64 {
+	vector := float64(dimensions) * float64(elementSizes[elementType])
+	links := 2*float64(m) + float64(m)/float64(m-1)
+	return vector + links*float64(model.LinkBytes) + float64(model.IndexEntryBytes)
+}
+
+// estimateCapacity projects the footprint of a normalized req on a cluster
+// of nodes. It fails when a size does not fit in an int64.
+func estimateCapacity(req CapacityRequest, nodes int) (CapacityEstimate, error) {
+	model := *req.Model
+	indexBytes, ok := ceilBytes(indexBytesPerRecord(model, req.Dimensions, req.M, req.ElementType) * float64(req.Records))
+	vector, ok2 := mulBytes(int64(req.Dimensions), int64(elementSizes[req.ElementType]))
+	perRecord, ok3 := addBytes(vector, req.MetadataBytes, model.PrimaryIndexBytes, model.RecordHeaderBytes)
+	recordBytes, ok4 := mulBytes(req.Records, perRecord)
+	if !ok || !ok2 || !ok3 || !ok4 {
+		return CapacityEstimate{}, newAPIError(ErrCodeBadRequest, "the estimate for %d records of %d dimensions is too large", req.Records, req.Dimensions)
+	}
+	estimate := CapacityEstimate{
+		Request:            req,
+		IndexMemoryBytes:   indexBytes,
+		RecordStorageBytes: recordBytes,
+		Nodes:              nodes,
+		Calibration:        []CapacityCheck{},
+	}
+	if nodes > 0 {
+		copies := int64(min(req.ReplicationFactor, nodes))
+		estimate.PerNodeIndexBytes = perNode(indexBytes, copies, int64(nodes))
+		estimate.PerNodeRecordBytes = perNode(recordBytes, copies, int64(nodes))
+	}
+	return estimate, nil
+}
+
+// perNode spreads copies of total bytes over nodes. It divides before
+// multiplying when the product would overflow, since copies <= nodes keeps
+// the result within total.
+func perNode(total, copies, nodes int64) int64 {
+	if product, ok := mulBytes(total, copies); ok {
+		return product / nodes
+	}
+	return total / nodes * copies
+}
+
+// mulBytes multiplies non-negative sizes, reporting false on overflow.
+func mulBytes(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	if a > math.MaxInt64/b {
+		return 0, false
+	}
+	return a * b, true
+}
+
+// addBytes sums non-negative sizes, reporting false on overflow.
+func addBytes(sizes ...int64) (int64, bool) {
+	var total int64
+	for _, size := range sizes {
+		if size > math.MaxInt64-total {
+			return 0, false
+		}
+		total += size
+	}
+	return total, true
+}
+
+// ceilBytes rounds a size up to whole bytes, reporting false when it does
+// not fit in an int64.
+func ceilBytes(size float64) (int64, bool) {
+	size = math.Ceil(size)
+	// float64(math.MaxInt64) rounds up to 2^63, which is already too large
+	if math.IsNaN(size) || size < 0 || size >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(size), true
+}
+
+// calibrate compares the estimator with the reported sizes of indexes that
+// hold vectors, using the model and calibration element types of the
+// estimate's request.
+func calibrate(estimate *CapacityEstimate, indexes []IndexInfo) {
+	req := estimate.Request
+	var ratios []float64
+	for _, index := range indexes {
+		actual, ok := parseByteSize(index.Size)
+		if !ok || actual == 0 || index.VectorRecords == 0 || index.Dimensions == 0 {
+			continue
+		}
+		m := defaultHNSWM
+		if value, err := strconv.Atoi(normalizeParameters(index.Parameters)["hnsw-m"]); err == nil && value > 1 {
+			m = value
+		}
+		elementType, named := req.CalibrationElementTypes[index.Name]
+		if !named {
+			elementType = defaultElementType
+		}
+		estimated, ok := ceilBytes(indexBytesPerRecord(*req.Model, index.Dimensions, m, elementType) * float64(index.VectorRecords))
+		if !ok || estimated == 0 {
+			continue
+		}
+		estimate.Calibration = append(estimate.Calibration, CapacityCheck{
+			Index:              index.Name,
+			Dimensions:         index.Dimensions,
+			VectorRecords:      index.VectorRecords,
+			M:                  m,
+			ElementType:        elementType,
+			ElementTypeAssumed: !named,
+			ActualBytes:        actual,
+			EstimatedBytes:     estimated,
+			ErrorPercent:       (float64(estimated) - float64(actual)) / float64(actual) * 100,
+		})
+		ratios = append(ratios, float64(actual)/float64(estimated))
+	}
+	if len(ratios) == 0 {
+		return
+	}
+	sort.Float64s(ratios)
+	factor := ratios[len(ratios)/2]
+	if len(ratios)%2 == 0 {
+		factor = (ratios[len(ratios)/2-1] + factor) / 2
+	}
+	estimate.CalibrationFactor = factor
+	// A calibrated size too large for an int64 is left out
+	estimate.CalibratedIndexBytes, _ = ceilBytes(float64(estimate.IndexMemoryBytes) * factor)
+}
+
+// byteUnits maps the unit suffixes asvec prints to their sizes.
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseByteSize reads sizes such as "1 MB" or "512KiB", rounding fractions
+// up to whole bytes. Sizes too large for an int64 are rejected.
+func parseByteSize(size string) (int64, bool) {
+	size = strings.TrimSpace(size)
+	split := strings.IndexFunc(size, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(size)
+	}
+	value, err := strconv.ParseFloat(size[:split], 64)
+	if err != nil {
+		return 0, false
+	}
+	unit, ok := byteUnits[strings.ToLower(strings.TrimSpace(size[split:]))]
+	if !ok {
+		return 0, false
+	}
+	return ceilBytes(value * unit)
+}
+
+// estimateIndexCapacity projects the footprint of a planned index on the
+// current cluster and checks the model against existing indexes.
+func estimateIndexCapacity(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling capacity estimate request from %s", r.RemoteAddr)
+
+	var req CapacityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	if err := normalizeCapacityRequest(&req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	nodes, err := listNodes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	indexes, err := listIndexes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	estimate, err := estimateCapacity(req, len(nodes))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	calibrate(&estimate, indexes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(estimate)
+}
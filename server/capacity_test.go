@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		ok   bool
+	}{
+		{"1 MB", 1e6, true},
+		{"512KiB", 512 << 10, true},
+		{" 2 GiB ", 2 << 30, true},
+		{"1.5 kb", 1500, true},
+		{"10", 10, true},
+		{"0.5 B", 1, true},
+		{"", 0, false},
+		{"MB", 0, false},
+		{"1 XB", 0, false},
+		{"-1 MB", 0, false},
+		{"1e3", 0, false},
+		{"99999999999 TB", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseByteSize(tt.size)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d, %v", tt.size, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeCapacityRequest(t *testing.T) {
+	req := CapacityRequest{Dimensions: 4, Records: 10, ElementType: "INT8", CalibrationElementTypes: map[string]string{"a": "Float16"}}
+	if err := normalizeCapacityRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.M != defaultHNSWM || req.ReplicationFactor != defaultReplication || req.ElementType != "int8" ||
+		*req.Model != defaultCapacityModel || req.CalibrationElementTypes["a"] != "float16" {
+		t.Errorf("req = %+v", req)
+	}
+
+	for name, req := range map[string]CapacityRequest{
+		"no dimensions":            {Records: 10},
+		"negative records":         {Dimensions: 4, Records: -1},
+		"m of 1":                   {Dimensions: 4, M: 1},
+		"unknown element type":     {Dimensions: 4, ElementType: "float8"},
+		"negative metadata":        {Dimensions: 4, MetadataBytes: -1},
+		"negative model size":      {Dimensions: 4, Model: &CapacityModel{LinkBytes: -8}},
+		"unknown calibration type": {Dimensions: 4, CalibrationElementTypes: map[string]string{"a": "float8"}},
+	} {
+		if err := normalizeCapacityRequest(&req); err == nil || asAPIError(err).Code != ErrCodeBadRequest {
+			t.Errorf("%s: err = %v, want bad request", name, err)
+		}
+	}
+}
+
+func TestEstimateCapacity(t *testing.T) {
+	// The default model puts 16 + (32 + 16/15)*8 + 64 bytes of index memory
+	// and 16 + 100 + 64 + 48 bytes of record storage behind each of these
+	// records.
+	planned := CapacityRequest{Dimensions: 4, Records: 10, MetadataBytes: 100}
+	tests := []struct {
+		name                         string
+		req                          CapacityRequest
+		nodes                        int
+		index, records               int64
+		perNodeIndex, perNodeRecords int64
+		tooLarge                     bool
+	}{
+		{name: "three nodes", req: planned, nodes: 3, index: 3446, records: 2280, perNodeIndex: 2297, perNodeRecords: 1520},
+		{name: "fewer nodes than copies", req: planned, nodes: 1, index: 3446, records: 2280, perNodeIndex: 3446, perNodeRecords: 2280},
+		{name: "no nodes", req: planned, index: 3446, records: 2280},
+		{name: "no records", req: CapacityRequest{Dimensions: 4}, nodes: 3},
+		{name: "overheads replaced", req: CapacityRequest{Dimensions: 2, Records: 5, M: 2, Model: &CapacityModel{}}, nodes: 2, index: 40, records: 40, perNodeIndex: 40, perNodeRecords: 40},
+		{name: "index memory overflows", req: CapacityRequest{Dimensions: 1 << 30, Records: 1 << 40, ElementType: "float64"}, tooLarge: true},
+		{name: "record storage overflows", req: CapacityRequest{Dimensions: 1, Records: 1 << 40, MetadataBytes: 1 << 30, Model: &CapacityModel{}}, tooLarge: true},
+		{name: "record size overflows", req: CapacityRequest{Dimensions: 1, Records: 1, MetadataBytes: math.MaxInt64}, tooLarge: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			if err := normalizeCapacityRequest(&req); err != nil {
+				t.Fatal(err)
+			}
+			got, err := estimateCapacity(req, tt.nodes)
+			if tt.tooLarge {
+				if err == nil || asAPIError(err).Code != ErrCodeBadRequest {
+					t.Fatalf("err = %v, want bad request", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.IndexMemoryBytes != tt.index || got.RecordStorageBytes != tt.records ||
+				got.PerNodeIndexBytes != tt.perNodeIndex || got.PerNodeRecordBytes != tt.perNodeRecords {
+				t.Errorf("estimate = %d/%d bytes, %d/%d per node; want %d/%d, %d/%d",
+					got.IndexMemoryBytes, got.RecordStorageBytes, got.PerNodeIndexBytes, got.PerNodeRecordBytes,
+					tt.index, tt.records, tt.perNodeIndex, tt.perNodeRecords)
+			}
+		})
+	}
+}
+
+func TestPerNodeAvoidsOverflow(t *testing.T) {
+	if got, want := perNode(math.MaxInt64-1, 2, 4), int64((math.MaxInt64-1)/4*2); got != want {
+		t.Errorf("perNode = %d, want %d", got, want)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	req := CapacityRequest{Dimensions: 4, Records: 10, CalibrationElementTypes: map[string]string{"bytes": "int8"}}
+	if err := normalizeCapacityRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := estimateCapacity(req, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calibrate(&estimate, []IndexInfo{
+		// Estimated at 344534 bytes as float32 and 332534 as int8
+		{Name: "floats", Dimensions: 4, VectorRecords: 1000, Size: "689068 B"},
+		{Name: "bytes", Dimensions: 4, VectorRecords: 1000, Size: "332534"},
+		{Name: "empty", Dimensions: 4, Size: "1 MB"},
+		{Name: "unsized", Dimensions: 4, VectorRecords: 1000},
+	})
+
+	if len(estimate.Calibration) != 2 {
+		t.Fatalf("calibration = %+v", estimate.Calibration)
+	}
+	floats, bytes := estimate.Calibration[0], estimate.Calibration[1]
+	if floats.ElementType != "float32" || !floats.ElementTypeAssumed || floats.EstimatedBytes != 344534 || floats.ErrorPercent != -50 {
+		t.Errorf("floats = %+v", floats)
+	}
+	if bytes.ElementType != "int8" || bytes.ElementTypeAssumed || bytes.EstimatedBytes != 332534 || bytes.ErrorPercent != 0 {
+		t.Errorf("bytes = %+v", bytes)
+	}
+	if estimate.CalibrationFactor != 1.5 || estimate.CalibratedIndexBytes != int64(math.Ceil(float64(estimate.IndexMemoryBytes)*1.5)) {
+		t.Errorf("factor = %v, calibrated = %d", estimate.CalibrationFactor, estimate.CalibratedIndexBytes)
+	}
+}
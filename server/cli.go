@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CLISupport is one row of the supported asvec version matrix: asvec
+// versions from CLIMin up to but excluding CLIBelow manage AVS servers from
+// ServerMin up to but excluding ServerBelow.
+type CLISupport struct {
+	CLIMin      string `json:"cliMin"`
+	CLIBelow    string `json:"cliBelow"`
+	ServerMin   string `json:"serverMin"`
+	ServerBelow string `json:"serverBelow"`
+	// Format is the --format the console asks listings in.
+	Format string `json:"format"`
+}
+
+// csvColumn locates a field of an asvec listing: at Pos, as long as the
+// header there is one of Names, and otherwise wherever one of Names is. A
+// Pos of -1 marks a column found only by name, such as one printed only
+// with --verbose.
+type csvColumn struct {
+	Pos   int
+	Names []string
+}
+
+// csvLayout maps the fields the console reads to their columns.
+type csvLayout map[string]csvColumn
+
+// cliProfile is how the console talks to one range of asvec versions.
+type cliProfile struct {
+	support CLISupport
+	nodes   csvLayout
+	indexes csvLayout
+}
+
+// listArgs returns the arguments of an asvec listing command in the
+// profile's format.
+func (p *cliProfile) listArgs(args ...string) []string {
+	return append(args, "--format", p.support.Format)
+}
+
+// The layouts below are the column order the console expects of each
+// listing; they have not been checked against output captured from every
+// asvec release. A field whose header is not at its expected position is
+// looked up by name, so a listing whose columns moved still parses. The
+// first column is the row number.
+var (
+	// nodeLayout is the `node ls` listing, used for every asvec version.
+	// Listeners, seeds, load balancers and uptime are only looked up by
+	// name, since not every version prints them even with --verbose.
+	nodeLayout = csvLayout{
+		"id":        {1, []string{"node", "node id", "id"}},
+		"role":      {2, []string{"roles", "role"}},
+		"endpoint":  {3, []string{"endpoint", "endpoints"}},
+		"cluster":   {4, []string{"cluster id", "cluster"}},
+		"version":   {5, []string{"version"}},
+		"peers":     {6, []string{"visible nodes", "peers", "visible peers"}},
+		"listeners": {-1, []string{"listener name", "listeners", "listener"}},
+		"seed":      {-1, []string{"seed", "is seed"}},
+		"lb":        {-1, []string{"lb", "load balancer", "is lb"}},
+		"uptime":    {-1, []string{"uptime", "up time"}},
+	}
+	// indexLayoutV2 is the `index ls --verbose` layout assumed for asvec 2,
+	// without mode or status columns.
+	indexLayoutV2 = csvLayout{
+		"name":            {1, []string{"name"}},
+		"namespace":       {2, []string{"namespace"}},
+		"set":             {3, []string{"set"}},
+		"field":           {4, []string{"field"}},
+		"dimensions":      {5, []string{"dimensions"}},
+		"distanceMetric":  {6, []string{"distance metric"}},
+		"unmerged":        {7, []string{"unmerged"}},
+		"vectorRecords":   {8, []string{"vector records"}},
+		"size":            {9, []string{"size"}},
+		"unmergedPercent": {10, []string{"unmerged %"}},
+		"labels":          {11, []string{"labels*", "labels"}},
+		"storage":         {12, []string{"storage"}},
+		"parameters":      {13, []string{"index parameters"}},
+		"mode":            {-1, []string{"mode"}},
+		"status":          {-1, []string{"status"}},
+		"vertices":        {-1, []string{"vertices"}},
+	}
+	// indexLayoutV3 is the `index ls --verbose` layout assumed for asvec 3,
+	// with mode and status columns after the unmerged percentage.
+	indexLayoutV3 = csvLayout{
+		"name":            {1, []string{"name"}},
+		"namespace":       {2, []string{"namespace"}},
+		"set":             {3, []string{"set"}},
+		"field":           {4, []string{"field"}},
+		"dimensions":      {5, []string{"dimensions"}},
+		"distanceMetric":  {6, []string{"distance metric"}},
+		"unmerged":        {7, []string{"unmerged"}},
+		"vectorRecords":   {8, []string{"vector records"}},
+		"size":            {9, []string{"size"}},
+		"unmergedPercent": {10, []string{"unmerged %"}},
+		"mode":            {11, []string{"mode"}},
+		"status":          {12, []string{"status"}},
+		"labels":          {13, []string{"labels*", "labels"}},
+		"storage":         {14, []string{"storage"}},
+		"parameters":      {15, []string{"index parameters"}},
+		"vertices":        {-1, []string{"vertices"}},
+	}
+)
+
+// cliProfiles is the supported version matrix, oldest first. asvec 1
+// printed listings only as tables, so it is not supported. Format "1" is
+// asvec's CSV output.
+var cliProfiles = []cliProfile{
+	{support: CLISupport{CLIMin: "2.0.0", CLIBelow: "3.0.0", ServerMin: "0.11.0", ServerBelow: "1.1.0", Format: "1"}, nodes: nodeLayout, indexes: indexLayoutV2},
+	{support: CLISupport{CLIMin: "3.0.0", CLIBelow: "4.0.0", ServerMin: "1.0.0", ServerBelow: "2.0.0", Format: "1"}, nodes: nodeLayout, indexes: indexLayoutV3},
+}
+
+// cli is the asvec the console detected at startup. Until detection, or
+// when asvec cannot be asked, the newest profile is assumed.
+var cli = detectedCLI{profile: &cliProfiles[len(cliProfiles)-1]}
+
+type detectedCLI struct {
+	profile *cliProfile
+}
+
+// supportedCLIVersions returns the version matrix.
+func supportedCLIVersions() []CLISupport {
+	matrix := make([]CLISupport, len(cliProfiles))
+	for i, profile := range cliProfiles {
+		matrix[i] = profile.support
+	}
+	return matrix
+}
+
+// minCLIVersion is the oldest asvec the console works with.
+func minCLIVersion() string {
+	return cliProfiles[0].support.CLIMin
+}
+
+// cliProfileFor returns the profile of an asvec version, or nil if the
+// version is outside the matrix.
+func cliProfileFor(version string) *cliProfile {
+	for i := range cliProfiles {
+		support := cliProfiles[i].support
+		if compareVersions(version, support.CLIMin) >= 0 && compareVersions(version, support.CLIBelow) < 0 {
+			return &cliProfiles[i]
+		}
+	}
+	return nil
+}
+
+// detectCLI asks the installed asvec for its version and picks the profile
+// used to parse its output. It fails only when asvec is older than the
+// console supports; a missing asvec is reported by the config page instead.
+func detectCLI(ctx context.Context) error {
+	version, err := installedCLIVersion(ctx)
+	if err != nil {
+		logger.Printf("Could not detect the asvec version, assuming %s or newer: %v", cli.profile.support.CLIMin, err)
+		return nil
+	}
+	if compareVersions(version, minCLIVersion()) < 0 {
+		return fmt.Errorf("asvec %s is too old: the console needs asvec %s or newer, from https://github.com/aerospike/asvec", version, minCLIVersion())
+	}
+
+	profile := cliProfileFor(version)
+	if profile == nil {
+		profile = &cliProfiles[len(cliProfiles)-1]
+		logger.Printf("asvec %s is newer than the console knows; parsing its output as asvec %s", version, profile.support.CLIMin)
+	}
+	cli = detectedCLI{profile: profile}
+	logger.Printf("Detected asvec %s (supports AVS >= %s, < %s)", version, profile.support.ServerMin, profile.support.ServerBelow)
+	return nil
+}
+
+// csvTable is an asvec listing: a title line, a header line and one record
+// per row. Fields holding commas or line breaks are quoted, and asvec
+// escapes the commas of nested tables, such as the index parameters, as
+// "\,".
+type csvTable struct {
+	headers []string
+	rows    [][]string
+}
+
+func parseCSVTable(output []byte) csvTable {
+	var records [][]string
+	var record []string
+	var value strings.Builder
+	quoted := false
+	text := string(output)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && (text[i+1] == ',' || text[i+1] == '"'):
+			i++
+			value.WriteByte(text[i])
+		case quoted && c == '"' && i+1 < len(text) && text[i+1] == '"':
+			i++
+			value.WriteByte('"')
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			value.WriteByte(c)
+		case c == ',':
+			record = append(record, value.String())
+			value.Reset()
+		case c == '\n':
+			records = append(records, append(record, value.String()))
+			record = nil
+			value.Reset()
+		case c != '\r':
+			value.WriteByte(c)
+		}
+	}
+	if record != nil || value.Len() > 0 {
+		records = append(records, append(record, value.String()))
+	}
+
+	var table csvTable
+	if len(records) > 1 {
+		table.headers = records[1]
+	}
+	for _, row := range records[min(2, len(records)):] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		table.rows = append(table.rows, row)
+	}
+	return table
+}
+
+// columns resolves layout against the table's headers. A field whose header
+// is not where the layout expects it is looked up by name, and failing that
+// read from its expected position. Fields found nowhere are left out.
+func (t csvTable) columns(layout csvLayout) map[string]int {
+	matches := func(header string, names []string) bool {
+		for _, name := range names {
+			if strings.EqualFold(strings.TrimSpace(header), name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	columns := make(map[string]int, len(layout))
+	for field, column := range layout {
+		if column.Pos >= 0 {
+			columns[field] = column.Pos
+			if column.Pos < len(t.headers) && matches(t.headers[column.Pos], column.Names) {
+				continue
+			}
+		}
+		for i, header := range t.headers {
+			if matches(header, column.Names) {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// field returns a row's trimmed value for a resolved field, or "" when the
+// field was not found or the row is too short.
+func field(row []string, columns map[string]int, name string) string {
+	if i, ok := columns[name]; ok && i < len(row) {
+		return strings.TrimSpace(row[i])
+	}
+	return ""
+}
+
+// parseNodeTable reads the nodes of `asvec node ls` output.
+func parseNodeTable(output []byte, layout csvLayout) []Node {
+	table := parseCSVTable(output)
+	columns := table.columns(layout)
+	var nodes []Node
+	for _, row := range table.rows {
+		node := Node{
+			NodeID:   field(row, columns, "id"),
+			Role:     field(row, columns, "role"),
+			Endpoint: field(row, columns, "endpoint"),
+			Version:  field(row, columns, "version"),
+		}
+		if node.NodeID == "" {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// parseIndexTable reads the indexes of `asvec index ls --verbose` output.
+func parseIndexTable(output []byte, layout csvLayout) []IndexInfo {
+	table := parseCSVTable(output)
+	columns := table.columns(layout)
+	indexes := []IndexInfo{}
+	for _, row := range table.rows {
+		name := field(row, columns, "name")
+		if name == "" {
+			continue
+		}
+
+		// Parameters are a nested table, one "name,value" pair per line,
+		// under a heading line such as "HNSW"
+		params := make(map[string]string)
+		for _, line := range strings.Split(field(row, columns, "parameters"), "\n") {
+			if key, value, ok := strings.Cut(line, ","); ok {
+				params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+
+		// Storage is a nested table too, of the namespace and set the
+		// index is stored in
+		var storage string
+		for _, line := range strings.Split(field(row, columns, "storage"), "\n") {
+			if key, value, ok := strings.Cut(line, ","); ok && strings.EqualFold(strings.TrimSpace(key), "namespace") {
+				storage = strings.TrimSpace(value)
+			}
+		}
+
+		// Labels are printed as a Go map, e.g. "map[team:search]"
+		labels := make(map[string]string)
+		labelField := strings.TrimSuffix(strings.TrimPrefix(field(row, columns, "labels"), "map["), "]")
+		for _, pair := range strings.Fields(labelField) {
+			if key, value, ok := strings.Cut(pair, ":"); ok {
+				labels[key] = value
+			}
+		}
+
+		dimensions, _ := strconv.Atoi(field(row, columns, "dimensions"))
+		unmerged, _ := strconv.Atoi(field(row, columns, "unmerged"))
+		vectorRecords, _ := strconv.Atoi(field(row, columns, "vectorRecords"))
+		vertices, _ := strconv.Atoi(field(row, columns, "vertices"))
+		indexes = append(indexes, IndexInfo{
+			Name:            name,
+			Namespace:       field(row, columns, "namespace"),
+			Set:             field(row, columns, "set"),
+			Field:           field(row, columns, "field"),
+			Dimensions:      dimensions,
+			DistanceMetric:  field(row, columns, "distanceMetric"),
+			Unmerged:        unmerged,
+			VectorRecords:   vectorRecords,
+			Size:            field(row, columns, "size"),
+			UnmergedPercent: field(row, columns, "unmergedPercent"),
+			Mode:            field(row, columns, "mode"),
+			Status:          field(row, columns, "status"),
+			Vertices:        vertices,
+			Labels:          labels,
+			Storage:         storage,
+			Parameters:      params,
+		})
+	}
+	return indexes
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"0.11.1", "1.0.0", -1},
+		{"asvec version 3.0.0", "3.0.0", 0},
+		{"v2.1.0-rc1", "2.1.0", 0},
+		{"", "0.1", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestCLIProfileFor(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string // CLIMin of the profile, "" for none
+	}{
+		{"1.9.9", ""},
+		{"2.0.0", "2.0.0"},
+		{"asvec version 2.4.1", "2.0.0"},
+		{"3.0.0", "3.0.0"},
+		{"3.99", "3.0.0"},
+		{"4.0.0", ""},
+		{"unknown", ""},
+	}
+	for _, tt := range tests {
+		profile := cliProfileFor(tt.version)
+		got := ""
+		if profile != nil {
+			got = profile.support.CLIMin
+		}
+		if got != tt.want {
+			t.Errorf("cliProfileFor(%q) = %q, want %q", tt.version, got, tt.want)
+		}
+	}
+	if got := cliProfiles[1].listArgs("node", "ls"); !reflect.DeepEqual(got, []string{"node", "ls", "--format", "1"}) {
+		t.Errorf("listArgs = %q", got)
+	}
+}
+
+func TestParseCSVTable(t *testing.T) {
+	table := parseCSVTable([]byte("Users\r\n,User,Roles\r\n1,admin,\"admin, read-write\"\r\n2,a\\,b,\"say \"\"hi\"\"\nthere\"\r\n\r\n"))
+	if !reflect.DeepEqual(table.headers, []string{"", "User", "Roles"}) {
+		t.Errorf("headers = %q", table.headers)
+	}
+	want := [][]string{{"1", "admin", "admin, read-write"}, {"2", "a,b", "say \"hi\"\nthere"}}
+	if !reflect.DeepEqual(table.rows, want) {
+		t.Errorf("rows = %q", table.rows)
+	}
+}
+
+func TestCSVTableColumns(t *testing.T) {
+	layout := csvLayout{
+		"id":      {1, []string{"node"}},
+		"version": {5, []string{"version"}},
+		"uptime":  {-1, []string{"uptime"}},
+		"peers":   {-1, []string{"peers"}},
+	}
+	tests := []struct {
+		name    string
+		headers []string
+		want    map[string]int
+	}{
+		{"expected positions", []string{"", "Node", "Roles", "Endpoint", "Cluster ID", "Version", "Uptime"},
+			map[string]int{"id": 1, "version": 5, "uptime": 6}},
+		{"moved column", []string{"", "Node", "Version", "Roles"},
+			map[string]int{"id": 1, "version": 2}},
+		{"renamed column", []string{"", "Node", "Roles", "Endpoint", "Cluster ID", "Release"},
+			map[string]int{"id": 1, "version": 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (csvTable{headers: tt.headers}).columns(layout); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("columns = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNodeTable(t *testing.T) {
+	listing := "Nodes\n" +
+		",Node,Roles,Endpoint,Cluster ID,Version,Visible Nodes\n" +
+		"1,139637976803088,\"INDEXER, QUERY\",10.0.0.1:5000,4412367243,1.1.0,\"map[139637976803089:[10.0.0.2:5000]]\"\n" +
+		"2,139637976803089,QUERY,10.0.0.2:5000,4412367243,1.1.0,\"map[139637976803088:[10.0.0.1:5000]]\"\n"
+	want := []Node{
+		{NodeID: "139637976803088", Role: "INDEXER, QUERY", Endpoint: "10.0.0.1:5000", Version: "1.1.0"},
+		{NodeID: "139637976803089", Role: "QUERY", Endpoint: "10.0.0.2:5000", Version: "1.1.0"},
+	}
+	if got := parseNodeTable([]byte(listing), nodeLayout); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodes = %+v", got)
+	}
+}
+
+func TestParseIndexTable(t *testing.T) {
+	const storage = "\"Namespace\\,test\nSet\\,docs-index\""
+	const parameters = "\"HNSW\nm\\,16\nef\\,100\nef-construction\\,100\""
+	for _, tt := range []struct {
+		name         string
+		layout       csvLayout
+		listing      string
+		mode, status string
+	}{
+		{"without mode and status", indexLayoutV2, "Indexes\n" +
+			",Name,Namespace,Set,Field,Dimensions,Distance Metric,Unmerged,Vector Records,Size,Unmerged %,Labels*,Storage,Index Parameters\n" +
+			"1,docs,test,articles,embedding,384,COSINE,12,50000,75 MB,0.02%,map[team:search]," + storage + "," + parameters + "\n" +
+			"2,images,test,,vec,512,SQUARED_EUCLIDEAN,0,0,0 B,0%,map[],,\"HNSW\nm\\,32\"\n", "", ""},
+		{"with mode and status", indexLayoutV3, "Indexes\n" +
+			",Name,Namespace,Set,Field,Dimensions,Distance Metric,Unmerged,Vector Records,Size,Unmerged %,Mode,Status,Labels*,Storage,Index Parameters\n" +
+			"1,docs,test,articles,embedding,384,COSINE,12,50000,75 MB,0.02%,DISTRIBUTED,READY,map[team:search]," + storage + "," + parameters + "\n" +
+			"2,images,test,,vec,512,SQUARED_EUCLIDEAN,0,0,0 B,0%,STANDALONE,CREATING,map[],,\"HNSW\nm\\,32\"\n", "DISTRIBUTED", "READY"},
+		{"mode and status in the other layout", indexLayoutV2, "Indexes\n" +
+			",Name,Namespace,Set,Field,Dimensions,Distance Metric,Unmerged,Vector Records,Size,Unmerged %,Mode,Status,Labels*,Storage,Index Parameters\n" +
+			"1,docs,test,articles,embedding,384,COSINE,12,50000,75 MB,0.02%,DISTRIBUTED,READY,map[team:search]," + storage + "," + parameters + "\n" +
+			"2,images,test,,vec,512,SQUARED_EUCLIDEAN,0,0,0 B,0%,STANDALONE,CREATING,map[],,\"HNSW\nm\\,32\"\n", "DISTRIBUTED", "READY"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			indexes := parseIndexTable([]byte(tt.listing), tt.layout)
+			if len(indexes) != 2 {
+				t.Fatalf("indexes = %+v", indexes)
+			}
+			docs := indexes[0]
+			if docs.Name != "docs" || docs.Namespace != "test" || docs.Set != "articles" || docs.Field != "embedding" ||
+				docs.Dimensions != 384 || docs.DistanceMetric != "COSINE" || docs.Unmerged != 12 || docs.VectorRecords != 50000 ||
+				docs.Size != "75 MB" || docs.UnmergedPercent != "0.02%" || docs.Mode != tt.mode || docs.Status != tt.status ||
+				docs.Storage != "test" {
+				t.Errorf("docs = %+v", docs)
+			}
+			if !reflect.DeepEqual(docs.Labels, map[string]string{"team": "search"}) {
+				t.Errorf("labels = %v", docs.Labels)
+			}
+			if want := map[string]string{"m": "16", "ef": "100", "ef-construction": "100"}; !reflect.DeepEqual(docs.Parameters, want) {
+				t.Errorf("parameters = %v", docs.Parameters)
+			}
+			if images := indexes[1]; images.Set != "" || images.Dimensions != 512 || len(images.Labels) != 0 || images.Parameters["m"] != "32" {
+				t.Errorf("images = %+v", images)
+			}
+		})
+	}
+}
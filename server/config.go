@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerConfig holds the tunables of the API server. Values are read from
+// AVS_CONSOLE_* environment variables and fall back to the defaults below.
+type ServerConfig struct {
+	// ShutdownTimeout is how long in-flight requests are given to finish
+	// after a termination signal before their contexts are cancelled.
+	ShutdownTimeout time.Duration
+	// MaxBackendCalls bounds the number of asvec subprocesses running at once.
+	MaxBackendCalls int
+	// MaxBatchCalls bounds the searches run at once by benchmarks and batch
+	// queries. They do not count towards MaxBackendCalls.
+	MaxBatchCalls int
+	// DefaultTimeout applies to asvec operations without their own entry in
+	// Timeouts.
+	DefaultTimeout time.Duration
+	// Timeouts holds per-operation limits keyed by operation name, e.g.
+	// "index-ls" or "query".
+	Timeouts map[string]time.Duration
+	// DataDir holds state that must survive a restart, such as jobs.
+	DataDir string
+	// JobConcurrency overrides how many jobs of each type run at once,
+	// keyed by job type.
+	JobConcurrency map[string]int
+	// Embedder selects the provider that turns query text into vectors.
+	Embedder EmbedderConfig
+	// UserHeader names the request header, set by an authenticating proxy,
+	// that identifies the user for per-user state such as query history.
+	UserHeader string
+	// QueryHistorySize bounds the history entries kept per user.
+	QueryHistorySize int
+	// AlertInterval is how often alert rules are evaluated.
+	AlertInterval time.Duration
+	// IndexProfile holds the recommended HNSW parameters, keyed by asvec
+	// flag name, that the health analyzer compares indexes with.
+	IndexProfile map[string]string
+}
+
+// EmbedderConfig configures the embedding provider used for text queries.
+type EmbedderConfig struct {
+	// Provider is "http", "onnx" or empty to disable text queries.
+	Provider string
+	// URL is the embeddings endpoint of an OpenAI-compatible server, e.g.
+	// "http://localhost:11434/v1/embeddings".
+	URL    string
+	Model  string
+	APIKey string
+	// ModelPath is the model file used by the onnx provider.
+	ModelPath string
+	// CacheSize is the number of embeddings kept in memory, keyed by text.
+	CacheSize int
+}
+
+// serverConfig is loaded in init once the logger is available.
+var serverConfig ServerConfig
+
+func loadServerConfig() ServerConfig {
+	cfg := ServerConfig{
+		ShutdownTimeout: envDuration("AVS_CONSOLE_SHUTDOWN_TIMEOUT", 15*time.Second),
+		MaxBackendCalls: envInt("AVS_CONSOLE_MAX_BACKEND_CALLS", 8),
+		MaxBatchCalls:   envInt("AVS_CONSOLE_MAX_BATCH_CALLS", 4),
+		DefaultTimeout:  envDuration("AVS_CONSOLE_BACKEND_TIMEOUT", 30*time.Second),
+		Timeouts: map[string]time.Duration{
+			"index-ls":        60 * time.Second,
+			"version":         5 * time.Second,
+			"embed":           30 * time.Second,
+			"notify":          10 * time.Second,
+			"connection-test": 10 * time.Second,
+		},
+		DataDir:          envString("AVS_CONSOLE_DATA_DIR", defaultDataDir()),
+		JobConcurrency:   map[string]int{},
+		UserHeader:       envString("AVS_CONSOLE_USER_HEADER", "X-Forwarded-User"),
+		QueryHistorySize: envInt("AVS_CONSOLE_QUERY_HISTORY_SIZE", 200),
+		AlertInterval:    envDuration("AVS_CONSOLE_ALERT_INTERVAL", 30*time.Second),
+		IndexProfile: map[string]string{
+			"hnsw-m":               "16",
+			"hnsw-ef-construction": "100",
+			"hnsw-ef":              "100",
+		},
+		Embedder: EmbedderConfig{
+			Provider:  strings.ToLower(os.Getenv("AVS_CONSOLE_EMBEDDER")),
+			URL:       os.Getenv("AVS_CONSOLE_EMBEDDING_URL"),
+			Model:     os.Getenv("AVS_CONSOLE_EMBEDDING_MODEL"),
+			APIKey:    os.Getenv("AVS_CONSOLE_EMBEDDING_API_KEY"),
+			ModelPath: os.Getenv("AVS_CONSOLE_EMBEDDING_MODEL_PATH"),
+			CacheSize: envInt("AVS_CONSOLE_EMBEDDING_CACHE_SIZE", 1000),
+		},
+	}
+
+	// AVS_CONSOLE_BACKEND_TIMEOUTS overrides individual operations, for
+	// example "index-ls=90s,query=10s".
+	for op, value := range envPairs("AVS_CONSOLE_BACKEND_TIMEOUTS") {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			logger.Printf("Ignoring invalid timeout for %s: %q", op, value)
+			continue
+		}
+		cfg.Timeouts[op] = d
+	}
+
+	// AVS_CONSOLE_JOB_CONCURRENCY limits jobs per type, for example
+	// "benchmark=1".
+	for jobType, value := range envPairs("AVS_CONSOLE_JOB_CONCURRENCY") {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			logger.Printf("Ignoring invalid job concurrency for %s: %q", jobType, value)
+			continue
+		}
+		cfg.JobConcurrency[jobType] = n
+	}
+
+	// AVS_CONSOLE_INDEX_PROFILE replaces the recommended index parameters,
+	// for example "hnsw-m=32,hnsw-ef=200".
+	if profile := envPairs("AVS_CONSOLE_INDEX_PROFILE"); len(profile) > 0 {
+		cfg.IndexProfile = normalizeParameters(profile)
+	}
+
+	if cfg.AlertInterval <= 0 {
+		cfg.AlertInterval = 30 * time.Second
+	}
+	if cfg.MaxBackendCalls < 1 {
+		cfg.MaxBackendCalls = 1
+	}
+	if cfg.MaxBatchCalls < 1 {
+		cfg.MaxBatchCalls = 1
+	}
+	return cfg
+}
+
+// timeoutFor returns the configured timeout for a backend operation.
+func (c ServerConfig) timeoutFor(op string) time.Duration {
+	if d, ok := c.Timeouts[op]; ok {
+		return d
+	}
+	return c.DefaultTimeout
+}
+
+// defaultDataDir keeps state in the user's config directory, falling back to
+// the temporary directory when there is none.
+func defaultDataDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "avs-console")
+	}
+	return filepath.Join(os.TempDir(), "avs-console")
+}
+
+// envPairs parses a comma-separated list of name=value entries.
+func envPairs(name string) map[string]string {
+	pairs := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return pairs
+}
+
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		logger.Printf("Ignoring invalid %s=%q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Printf("Ignoring invalid %s=%q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
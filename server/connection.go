@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultAVSPort is used for hosts and seeds given without a port.
+const defaultAVSPort = "5000"
+
+// ConnectionTestRequest describes the cluster to test. Host is a single
+// "host:port", typically a load balancer; Seeds is a comma-separated list of
+// them. When both are empty the host and seeds of the asvec config file are
+// tested.
+type ConnectionTestRequest struct {
+	Host         string             `json:"host,omitempty"`
+	Seeds        string             `json:"seeds,omitempty"`
+	ListenerName string             `json:"listenerName,omitempty"`
+	TLS          *TLSProfile        `json:"tls,omitempty"`
+	Credentials  *ClusterCredential `json:"credentials,omitempty"`
+}
+
+// TLSProfile holds the TLS settings of a connection. Files are paths on the
+// console's host.
+type TLSProfile struct {
+	CAFile   string `json:"caFile,omitempty"`
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ServerName overrides the host name the certificate is checked
+	// against.
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify reports certificate problems without failing the
+	// stage.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// ClusterCredential is an AVS user and password.
+type ClusterCredential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ConnectionStage is the result of one stage of a connection test. Status
+// is "ok", "failed" or "skipped".
+type ConnectionStage struct {
+	Name      string  `json:"name"`
+	Endpoint  string  `json:"endpoint,omitempty"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Message   string  `json:"message"`
+	// Addresses are the addresses the dns stage resolved.
+	Addresses   []string         `json:"addresses,omitempty"`
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+}
+
+// CertificateInfo describes the certificate a server presented.
+type CertificateInfo struct {
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	DNSNames      []string  `json:"dnsNames"`
+	IPAddresses   []string  `json:"ipAddresses"`
+	NotBefore     time.Time `json:"notBefore"`
+	NotAfter      time.Time `json:"notAfter"`
+	ExpiresInDays int       `json:"expiresInDays"`
+	TLSVersion    string    `json:"tlsVersion"`
+	CipherSuite   string    `json:"cipherSuite"`
+	Verified      bool      `json:"verified"`
+	VerifyError   string    `json:"verifyError,omitempty"`
+}
+
+// ConnectionTestResponse lists the stages in the order they ran. OK is true
+// when no stage failed.
+type ConnectionTestResponse struct {
+	Host   string            `json:"host"`
+	Seeds  string            `json:"seeds"`
+	OK     bool              `json:"ok"`
+	Stages []ConnectionStage `json:"stages"`
+}
+
+// connectionTest runs the stages of a connection test in turn.
+type connectionTest struct {
+	req    ConnectionTestRequest
+	stages []ConnectionStage
+}
+
+// run times fn as a stage. fn returns a message for a stage that passed or
+// an error for one that failed.
+func (c *connectionTest) run(ctx context.Context, stage ConnectionStage, fn func(context.Context, *ConnectionStage) (string, error)) bool {
+	ctx, cancel := context.WithTimeout(ctx, serverConfig.timeoutFor("connection-test"))
+	defer cancel()
+
+	start := time.Now()
+	message, err := fn(ctx, &stage)
+	stage.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Details != "":
+		stage.Status, stage.Message = "failed", apiErr.Message+": "+apiErr.Details
+	case errors.As(err, &apiErr):
+		stage.Status, stage.Message = "failed", apiErr.Message
+	case err != nil:
+		stage.Status, stage.Message = "failed", err.Error()
+	default:
+		stage.Status, stage.Message = "ok", message
+	}
+	c.stages = append(c.stages, stage)
+	return err == nil
+}
+
+func (c *connectionTest) skip(name, endpoint, reason string) {
+	c.stages = append(c.stages, ConnectionStage{Name: name, Endpoint: endpoint, Status: "skipped", Message: reason})
+}
+
+// endpoints returns the host or seeds as host:port pairs.
+func (c *connectionTest) endpoints() []string {
+	list := []string{c.req.Host}
+	if c.req.Host == "" {
+		list = strings.Split(c.req.Seeds, ",")
+	}
+	var endpoints []string
+	for _, endpoint := range list {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			endpoint = net.JoinHostPort(strings.Trim(endpoint, "[]"), defaultAVSPort)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+// testEndpoint runs the dns, tcp and tls stages against one endpoint and
+// reports whether all of them passed.
+func (c *connectionTest) testEndpoint(ctx context.Context, endpoint string) bool {
+	host, port, _ := net.SplitHostPort(endpoint)
+
+	var addresses []string
+	ok := c.run(ctx, ConnectionStage{Name: "dns", Endpoint: endpoint}, func(ctx context.Context, stage *ConnectionStage) (string, error) {
+		var err error
+		addresses, err = net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return "", err
+		}
+		stage.Addresses = addresses
+		return fmt.Sprintf("%s resolved to %s", host, strings.Join(addresses, ", ")), nil
+	})
+	if !ok {
+		c.skip("tcp", endpoint, "dns failed")
+		c.skip("tls", endpoint, "dns failed")
+		return false
+	}
+
+	var conn net.Conn
+	ok = c.run(ctx, ConnectionStage{Name: "tcp", Endpoint: endpoint}, func(ctx context.Context, stage *ConnectionStage) (string, error) {
+		var err error
+		address := net.JoinHostPort(addresses[0], port)
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return "", err
+		}
+		return "connected to " + address, nil
+	})
+	if !ok {
+		c.skip("tls", endpoint, "tcp connect failed")
+		return false
+	}
+	defer conn.Close()
+
+	if c.req.TLS == nil {
+		c.skip("tls", endpoint, "TLS is not configured")
+		return true
+	}
+	return c.run(ctx, ConnectionStage{Name: "tls", Endpoint: endpoint}, func(ctx context.Context, stage *ConnectionStage) (string, error) {
+		return c.handshake(ctx, conn, host, stage)
+	})
+}
+
+// handshake performs a TLS handshake on conn and checks the server's
+// certificate itself, so that its details are reported even when it does
+// not verify.
+func (c *connectionTest) handshake(ctx context.Context, conn net.Conn, host string, stage *ConnectionStage) (string, error) {
+	profile := c.req.TLS
+	serverName := host
+	if profile.ServerName != "" {
+		serverName = profile.ServerName
+	}
+	config := &tls.Config{ServerName: serverName, InsecureSkipVerify: true}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if profile.CAFile != "" {
+		pem, err := os.ReadFile(profile.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("reading the CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificates found in %s", profile.CAFile)
+		}
+	}
+	if profile.CertFile != "" || profile.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(profile.CertFile, profile.KeyFile)
+		if err != nil {
+			return "", fmt.Errorf("loading the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	client := tls.Client(conn, config)
+	if err := client.HandshakeContext(ctx); err != nil {
+		return "", err
+	}
+	state := client.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return "", errors.New("server presented no certificate")
+	}
+
+	leaf := state.PeerCertificates[0]
+	info := &CertificateInfo{
+		Subject:       leaf.Subject.String(),
+		Issuer:        leaf.Issuer.String(),
+		DNSNames:      append([]string{}, leaf.DNSNames...),
+		IPAddresses:   []string{},
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		ExpiresInDays: int(time.Until(leaf.NotAfter).Hours() / 24),
+		TLSVersion:    tls.VersionName(state.Version),
+		CipherSuite:   tls.CipherSuiteName(state.CipherSuite),
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Roots: roots, Intermediates: intermediates})
+	info.Verified = verifyErr == nil
+	if verifyErr != nil {
+		info.VerifyError = verifyErr.Error()
+	}
+	stage.Certificate = info
+
+	if verifyErr != nil && !profile.InsecureSkipVerify {
+		return "", fmt.Errorf("certificate does not verify: %w", verifyErr)
+	}
+	message := fmt.Sprintf("%s handshake with %s", info.TLSVersion, info.Subject)
+	if verifyErr != nil {
+		message += "; certificate does not verify"
+	}
+	return message, nil
+}
+
+// applyASVECConfig fills in what a connection test leaves out from the asvec
+// config: the host and seeds when neither is given, and the listener name,
+// TLS files and credentials when they are not given.
+func applyASVECConfig(req *ConnectionTestRequest, cfg asvecConfig) {
+	if req.Host == "" && req.Seeds == "" {
+		req.Host, req.Seeds = cfg.Host, cfg.Seeds
+	}
+	if req.ListenerName == "" {
+		req.ListenerName = cfg.ListenerName
+	}
+	if req.TLS == nil && (cfg.TLSCAFile != "" || cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" || cfg.TLSHostnameOverride != "") {
+		req.TLS = &TLSProfile{CAFile: cfg.TLSCAFile, CertFile: cfg.TLSCertFile, KeyFile: cfg.TLSKeyFile, ServerName: cfg.TLSHostnameOverride}
+	}
+	if req.Credentials == nil && cfg.Credentials != "" {
+		username, password, _ := strings.Cut(cfg.Credentials, ":")
+		req.Credentials = &ClusterCredential{Username: username, Password: password}
+	}
+}
+
+// asvecArgs returns the asvec flags and environment that point a command at
+// the tested cluster. Credentials go through the environment so that they
+// are not logged with the command line.
+func (c *connectionTest) asvecArgs(args ...string) ([]string, []string) {
+	if c.req.Host != "" {
+		args = append(args, "--host", c.req.Host)
+	} else {
+		args = append(args, "--seeds", c.req.Seeds)
+	}
+	if c.req.ListenerName != "" {
+		args = append(args, "--listener-name", c.req.ListenerName)
+	}
+	if profile := c.req.TLS; profile != nil {
+		flags := [][2]string{
+			{"--tls-cafile", profile.CAFile}, {"--tls-certfile", profile.CertFile},
+			{"--tls-keyfile", profile.KeyFile}, {"--tls-hostname-override", profile.ServerName},
+		}
+		for _, flag := range flags {
+			if flag[1] != "" {
+				args = append(args, flag[0], flag[1])
+			}
+		}
+	}
+	var env []string
+	if creds := c.req.Credentials; creds != nil && creds.Username != "" {
+		env = append(env, "ASVEC_CREDENTIALS="+creds.Username+":"+creds.Password)
+	}
+	return args, env
+}
+
+// testCluster runs the auth and cluster stages through asvec.
+func (c *connectionTest) testCluster(ctx context.Context) {
+	if creds := c.req.Credentials; creds == nil || creds.Username == "" {
+		c.skip("auth", "", "no credentials given")
+	} else {
+		c.run(ctx, ConnectionStage{Name: "auth"}, func(ctx context.Context, stage *ConnectionStage) (string, error) {
+			args, env := c.asvecArgs(cli.profile.listArgs("user", "ls")...)
+			_, err := asvec.ExecEnv(ctx, "connection-test", env, args...)
+			if err == nil {
+				return "authenticated as " + creds.Username, nil
+			}
+			// A user that may not list users has still authenticated
+			apiErr := backendError("list users", err)
+			if apiErr.Code == ErrCodePermissionDenied {
+				return "authenticated as " + creds.Username + " (not allowed to list users)", nil
+			}
+			return "", apiErr
+		})
+	}
+
+	c.run(ctx, ConnectionStage{Name: "cluster"}, func(ctx context.Context, stage *ConnectionStage) (string, error) {
+		args, env := c.asvecArgs(cli.profile.listArgs("node", "ls")...)
+		output, err := asvec.ExecEnv(ctx, "connection-test", env, args...)
+		if err != nil {
+			return "", backendError("get cluster info", err)
+		}
+		nodes := parseNodeTable(output, cli.profile.nodes)
+		if len(nodes) == 0 {
+			return "", newAPIError(ErrCodeBackend, "asvec returned no nodes")
+		}
+		return fmt.Sprintf("cluster answered with %d nodes", len(nodes)), nil
+	})
+}
+
+// testConnection runs a staged check of a connection to a cluster: DNS, TCP
+// and TLS against each endpoint, then authentication and a cluster call
+// through asvec. Stages that depend on a failed one are skipped.
+func testConnection(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling connection test request from %s", r.RemoteAddr)
+
+	var req ConnectionTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	applyASVECConfig(&req, readASVECConfig())
+	if req.Host == "" && req.Seeds == "" {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "give a host or seeds; none are set in %s", asvecConfigPath))
+		return
+	}
+
+	test := &connectionTest{req: req}
+	reachable := false
+	for _, endpoint := range test.endpoints() {
+		if test.testEndpoint(r.Context(), endpoint) {
+			reachable = true
+		}
+	}
+	if reachable {
+		test.testCluster(r.Context())
+	} else {
+		test.skip("auth", "", "no endpoint is reachable")
+		test.skip("cluster", "", "no endpoint is reachable")
+	}
+
+	response := ConnectionTestResponse{Host: req.Host, Seeds: req.Seeds, OK: true, Stages: test.stages}
+	for _, stage := range test.stages {
+		if stage.Status == "failed" {
+			response.OK = false
+		}
+	}
+	logger.Printf("Connection test of %s%s: ok=%t", req.Host, req.Seeds, response.OK)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
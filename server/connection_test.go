@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stageStatuses(stages []ConnectionStage) []string {
+	var list []string
+	for _, stage := range stages {
+		list = append(list, stage.Name+" "+stage.Status)
+	}
+	return list
+}
+
+func TestConnectionEndpoints(t *testing.T) {
+	tests := []struct {
+		req  ConnectionTestRequest
+		want []string
+	}{
+		{ConnectionTestRequest{Host: "lb.example.com"}, []string{"lb.example.com:5000"}},
+		{ConnectionTestRequest{Host: "lb:3000", Seeds: "ignored:1"}, []string{"lb:3000"}},
+		{ConnectionTestRequest{Seeds: "a:1, b ,,[::1]"}, []string{"a:1", "b:5000", "[::1]:5000"}},
+	}
+	for _, tt := range tests {
+		test := &connectionTest{req: tt.req}
+		if got := test.endpoints(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("endpoints(%+v) = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestConnectionAsvecArgs(t *testing.T) {
+	test := &connectionTest{req: ConnectionTestRequest{
+		Seeds: "a:1,b:2", ListenerName: "external",
+		TLS:         &TLSProfile{CAFile: "/ca.pem", ServerName: "avs"},
+		Credentials: &ClusterCredential{Username: "admin", Password: "secret"},
+	}}
+	args, env := test.asvecArgs("node", "ls")
+	want := []string{"node", "ls", "--seeds", "a:1,b:2", "--listener-name", "external", "--tls-cafile", "/ca.pem", "--tls-hostname-override", "avs"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q", args)
+	}
+	// The password never reaches the command line
+	if !reflect.DeepEqual(env, []string{"ASVEC_CREDENTIALS=admin:secret"}) || strings.Contains(strings.Join(args, " "), "secret") {
+		t.Errorf("env = %q", env)
+	}
+}
+
+func TestApplyASVECConfig(t *testing.T) {
+	cfg := parseASVECConfig([]byte(`default:
+  host: 10.0.0.1:5000
+  # seeds: 1.1.1.1:5000
+  listener-name: external
+  credentials: admin:pa:ss
+  tls-cafile: /ca.pem
+  tls-hostname-override: avs
+`))
+
+	var req ConnectionTestRequest
+	applyASVECConfig(&req, cfg)
+	want := ConnectionTestRequest{
+		Host: "10.0.0.1:5000", ListenerName: "external",
+		TLS:         &TLSProfile{CAFile: "/ca.pem", ServerName: "avs"},
+		Credentials: &ClusterCredential{Username: "admin", Password: "pa:ss"},
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("req = %+v", req)
+	}
+
+	// What the client gives is kept
+	given := ConnectionTestRequest{Seeds: "a:1", ListenerName: "internal", TLS: &TLSProfile{}, Credentials: &ClusterCredential{Username: "me"}}
+	req = given
+	applyASVECConfig(&req, cfg)
+	if !reflect.DeepEqual(req, given) {
+		t.Errorf("req = %+v", req)
+	}
+}
+
+func TestConnectionTestEndpoint(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedEndpoint := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		endpoint string
+		tls      *TLSProfile
+		ok       bool
+		stages   []string
+		verified bool
+	}{
+		{"plain", endpoint, nil, true, []string{"dns ok", "tcp ok", "tls skipped"}, false},
+		{"untrusted certificate", endpoint, &TLSProfile{ServerName: "example.com"}, false, []string{"dns ok", "tcp ok", "tls failed"}, false},
+		{"untrusted but allowed", endpoint, &TLSProfile{ServerName: "example.com", InsecureSkipVerify: true}, true, []string{"dns ok", "tcp ok", "tls ok"}, false},
+		{"trusted CA", endpoint, &TLSProfile{CAFile: caFile, ServerName: "example.com"}, true, []string{"dns ok", "tcp ok", "tls ok"}, true},
+		{"wrong server name", endpoint, &TLSProfile{CAFile: caFile, ServerName: "other.test"}, false, []string{"dns ok", "tcp ok", "tls failed"}, false},
+		{"closed port", closedEndpoint, nil, false, []string{"dns ok", "tcp failed", "tls skipped"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := &connectionTest{req: ConnectionTestRequest{Host: tt.endpoint, TLS: tt.tls}}
+			if ok := test.testEndpoint(context.Background(), tt.endpoint); ok != tt.ok {
+				t.Errorf("ok = %v", ok)
+			}
+			if got := stageStatuses(test.stages); !reflect.DeepEqual(got, tt.stages) {
+				t.Errorf("stages = %q (%+v)", got, test.stages)
+			}
+			if tt.tls != nil && len(test.stages) == 3 {
+				if cert := test.stages[2].Certificate; cert == nil || cert.Verified != tt.verified {
+					t.Errorf("certificate = %+v", cert)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionTestCluster(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$ASVEC_CREDENTIALS $1" in
+"admin:right user") ;;
+"reader:right user") echo 'rpc error: code = PermissionDenied' >&2; exit 1 ;;
+*" user") echo 'rpc error: code = Unauthenticated' >&2; exit 1 ;;
+*" node") printf 'Nodes\n,Node,Roles,Endpoint,Cluster ID,Version\n1,1,INDEXER,a:5000,c,1.1.0\n2,2,QUERY,b:5000,c,1.1.0\n' ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		creds  *ClusterCredential
+		stages []string
+	}{
+		{nil, []string{"auth skipped", "cluster ok"}},
+		{&ClusterCredential{Username: "admin", Password: "right"}, []string{"auth ok", "cluster ok"}},
+		{&ClusterCredential{Username: "reader", Password: "right"}, []string{"auth ok", "cluster ok"}},
+		{&ClusterCredential{Username: "admin", Password: "wrong"}, []string{"auth failed", "cluster ok"}},
+	}
+	for _, tt := range tests {
+		test := &connectionTest{req: ConnectionTestRequest{Host: "lb:5000", Credentials: tt.creds}}
+		test.testCluster(context.Background())
+		if got := stageStatuses(test.stages); !reflect.DeepEqual(got, tt.stages) {
+			t.Errorf("%+v: stages = %q (%+v)", tt.creds, got, test.stages)
+		}
+		if cluster := test.stages[1]; cluster.Message != "cluster answered with 2 nodes" {
+			t.Errorf("cluster stage = %+v", cluster)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// recentLogLines is how many server log lines are kept for diagnostics.
+	recentLogLines = 2000
+	// recentErrorCount is how many API errors are kept for diagnostics.
+	recentErrorCount = 200
+)
+
+// recentLogs keeps the latest server log lines; the logger writes to it as
+// well as to stdout.
+var recentLogs = &logRing{size: recentLogLines}
+
+// recentErrors keeps the latest errors returned by the API.
+var recentErrors = &errorRing{size: recentErrorCount}
+
+// logRing is an io.Writer that keeps the last size lines written to it.
+type logRing struct {
+	mu      sync.Mutex
+	size    int
+	lines   []string
+	partial string
+}
+
+func (l *logRing) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	text := l.partial + string(p)
+	lines := strings.Split(text, "\n")
+	l.partial = lines[len(lines)-1]
+	l.lines = append(l.lines, lines[:len(lines)-1]...)
+	if extra := len(l.lines) - l.size; extra > 0 {
+		l.lines = append([]string(nil), l.lines[extra:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines, oldest first.
+func (l *logRing) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// RecordedError is an error the API returned to a client.
+type RecordedError struct {
+	At        time.Time `json:"at"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"requestId"`
+	Status    int       `json:"status"`
+	Error     *APIError `json:"error"`
+}
+
+type errorRing struct {
+	mu     sync.Mutex
+	size   int
+	errors []RecordedError
+}
+
+func (e *errorRing) Record(r *http.Request, apiErr *APIError) {
+	copied := *apiErr
+	entry := RecordedError{
+		At: time.Now().UTC(), Method: r.Method, Path: r.URL.Path,
+		RequestID: apiErr.RequestID, Status: apiErr.Status(), Error: &copied,
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errors = append(e.errors, entry)
+	if extra := len(e.errors) - e.size; extra > 0 {
+		e.errors = append([]RecordedError(nil), e.errors[extra:]...)
+	}
+}
+
+// Errors returns the kept errors, oldest first.
+func (e *errorRing) Errors() []RecordedError {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]RecordedError{}, e.errors...)
+}
+
+// DiagnosticsEntry describes one file of a diagnostics bundle in its
+// manifest.json.
+type DiagnosticsEntry struct {
+	File        string    `json:"file"`
+	Command     string    `json:"command,omitempty"`
+	CollectedAt time.Time `json:"collectedAt"`
+	DurationMs  float64   `json:"durationMs"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// diagnosticsCommands are the asvec commands whose output goes into a
+// diagnostics bundle, by file name. Listings are asked for in the format of
+// the detected asvec.
+var diagnosticsCommands = []struct {
+	file    string
+	op      string
+	args    []string
+	listing bool
+}{
+	{"asvec-version.txt", "version", []string{"--version"}, false},
+	{"cluster-info.txt", "cluster-info", []string{"cluster", "info"}, false},
+	{"nodes.csv", "node-ls", []string{"node", "ls", "--verbose"}, true},
+	{"indexes.csv", "index-ls", []string{"index", "ls", "--verbose"}, true},
+	{"users.csv", "user-ls", []string{"user", "ls"}, true},
+	{"roles.csv", "role-ls", []string{"role", "ls"}, true},
+}
+
+// secretWords mark config keys and environment variables whose values are
+// redacted from diagnostics. Names ending in "file", such as tls-keyfile,
+// hold paths rather than secrets and are kept, since they help troubleshoot
+// TLS.
+var secretWords = []string{"password", "secret", "token", "credential", "key"}
+
+func isSecret(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, "file") {
+		return false
+	}
+	for _, word := range secretWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactYAML replaces the values of secret keys in an asvec config file,
+// keeping its layout and comments.
+func redactYAML(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				if value.Kind == yaml.ScalarNode && isSecret(key.Value) {
+					value.Value, value.Tag, value.Style = redacted, "!!str", 0
+				}
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(&doc)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// effectiveASVECConfig renders the config asvec runs with: the config file
+// and the ASVEC_* environment variables, with secrets redacted.
+func effectiveASVECConfig() ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# %s\n", asvecConfigPath)
+	content, err := os.ReadFile(asvecConfigPath)
+	if err == nil {
+		content, err = redactYAML(content)
+	}
+	if err != nil {
+		fmt.Fprintf(&out, "# unavailable: %v\n", err)
+	} else {
+		out.Write(content)
+	}
+
+	var env []string
+	for _, pair := range os.Environ() {
+		name, value, _ := strings.Cut(pair, "=")
+		if !strings.HasPrefix(name, "ASVEC_") {
+			continue
+		}
+		if isSecret(name) {
+			value = redacted
+		}
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	out.WriteString("\n# Environment\n")
+	for _, pair := range env {
+		fmt.Fprintf(&out, "# %s\n", pair)
+	}
+	return out.Bytes(), err
+}
+
+// diagnosticsBundle writes the zip entries of a bundle. A failure to collect
+// one entry is recorded in it and in the manifest rather than stopping the
+// bundle.
+type diagnosticsBundle struct {
+	zip      *zip.Writer
+	manifest []DiagnosticsEntry
+}
+
+func (b *diagnosticsBundle) add(entry DiagnosticsEntry, content []byte, err error) error {
+	if err != nil {
+		entry.Error = err.Error()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			entry.Error += ": " + strings.TrimSpace(string(exitErr.Stderr))
+		}
+		if len(content) == 0 {
+			content = []byte(entry.Error + "\n")
+		}
+	}
+	b.manifest = append(b.manifest, entry)
+	w, err := b.zip.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Deflate, Modified: entry.CollectedAt})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// collect runs fn and adds what it returns as file.
+func (b *diagnosticsBundle) collect(file, command string, fn func() ([]byte, error)) error {
+	start := time.Now()
+	content, err := fn()
+	entry := DiagnosticsEntry{
+		File: file, Command: command, CollectedAt: start.UTC(),
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	return b.add(entry, content, err)
+}
+
+// writeDiagnostics writes a diagnostics bundle to w as a zip archive.
+func writeDiagnostics(ctx context.Context, w *zip.Writer) error {
+	b := &diagnosticsBundle{zip: w}
+	for _, cmd := range diagnosticsCommands {
+		args := cmd.args
+		if cmd.listing {
+			args = cli.profile.listArgs(args...)
+		}
+		err := b.collect(cmd.file, "asvec "+strings.Join(args, " "), func() ([]byte, error) {
+			return asvec.Run(ctx, cmd.op, args...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := b.collect("asvec-config.yml", "", effectiveASVECConfig)
+	if err == nil {
+		err = b.collect("server.log", "", func() ([]byte, error) {
+			return []byte(strings.Join(recentLogs.Lines(), "\n") + "\n"), nil
+		})
+	}
+	if err == nil {
+		err = b.collect("errors.json", "", func() ([]byte, error) {
+			return json.MarshalIndent(recentErrors.Errors(), "", "  ")
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	manifest, _ := json.MarshalIndent(b.manifest, "", "  ")
+	return b.add(DiagnosticsEntry{File: "manifest.json", CollectedAt: time.Now().UTC()}, manifest, nil)
+}
+
+// getDiagnostics streams a zip of everything support usually asks for:
+// asvec output about the cluster, the redacted asvec config, recent server
+// logs and recent API errors. manifest.json lists when each file was
+// collected and why any could not be.
+func getDiagnostics(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling diagnostics request from %s", r.RemoteAddr)
+
+	name := fmt.Sprintf("avs-diagnostics-%s.zip", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	archive := zip.NewWriter(w)
+	if err := writeDiagnostics(r.Context(), archive); err != nil {
+		// The response has started, so all that can be done is to stop.
+		logger.Printf("Error writing diagnostics bundle: %v", err)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		logger.Printf("Error writing diagnostics bundle: %v", err)
+	}
+}
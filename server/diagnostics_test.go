@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsSecret(t *testing.T) {
+	for name, want := range map[string]bool{
+		"password":          true,
+		"ASVEC_PASSWORD":    true,
+		"api-key":           true,
+		"credentials":       true,
+		"tls-keyfile":       false,
+		"credentials-file":  false,
+		"host":              false,
+		"ASVEC_SEEDS":       false,
+		"AccessToken":       true,
+		"tls-certfile":      false,
+		"client-secret-ref": true,
+	} {
+		if got := isSecret(name); got != want {
+			t.Errorf("isSecret(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRedactYAML(t *testing.T) {
+	config := `# cluster config
+default:
+  host: 10.0.0.1:5000
+  credentials: admin:hunter2
+  tls-keyfile: /etc/avs/key.pem
+  nested:
+    password: hunter2 # inline comment
+    list:
+      - token: abc
+`
+	out, err := redactYAML([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(out)
+	if strings.Contains(text, "hunter2") || strings.Contains(text, "abc") {
+		t.Errorf("secret left in:\n%s", text)
+	}
+	for _, kept := range []string{"# cluster config", "host: 10.0.0.1:5000", "tls-keyfile: /etc/avs/key.pem", "# inline comment"} {
+		if !strings.Contains(text, kept) {
+			t.Errorf("%q missing from:\n%s", kept, text)
+		}
+	}
+	if _, err := redactYAML([]byte("a: [")); err == nil {
+		t.Error("invalid YAML accepted")
+	}
+}
+
+func TestLogRing(t *testing.T) {
+	ring := &logRing{size: 2}
+	ring.Write([]byte("one\ntw"))
+	ring.Write([]byte("o\nthree\nfour"))
+	if got := ring.Lines(); strings.Join(got, ",") != "two,three" {
+		t.Errorf("lines = %q", got)
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$*\" in\n\"cluster info\") echo 'connection refused' >&2; exit 1;;\n*) echo \"output of $*\";;\nesac\n"
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("ASVEC_PASSWORD", "hunter2")
+	t.Setenv("ASVEC_HOST", "10.0.0.1:5000")
+
+	var out bytes.Buffer
+	archive := zip.NewWriter(&out)
+	if err := writeDiagnostics(context.Background(), archive); err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, file := range zr.File {
+		member, _ := file.Open()
+		content, _ := io.ReadAll(member)
+		member.Close()
+		files[file.Name] = string(content)
+	}
+
+	if got := files["nodes.csv"]; got != "output of node ls --verbose --format 1\n" {
+		t.Errorf("nodes.csv = %q", got)
+	}
+	if got := files["cluster-info.txt"]; !strings.Contains(got, "connection refused") {
+		t.Errorf("cluster-info.txt = %q", got)
+	}
+	config := files["asvec-config.yml"]
+	if strings.Contains(config, "hunter2") || !strings.Contains(config, "ASVEC_PASSWORD="+redacted) || !strings.Contains(config, "ASVEC_HOST=10.0.0.1:5000") {
+		t.Errorf("asvec-config.yml = %q", config)
+	}
+
+	var manifest []DiagnosticsEntry
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]string{}
+	for _, entry := range manifest {
+		if _, ok := files[entry.File]; !ok {
+			t.Errorf("manifest lists missing file %s", entry.File)
+		}
+		if entry.Error != "" {
+			failed[entry.File] = entry.Error
+		}
+	}
+	if len(manifest) != len(files)-1 || !strings.Contains(failed["cluster-info.txt"], "connection refused") {
+		t.Errorf("manifest = %+v", manifest)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Embedder turns text into vectors for text queries.
+type Embedder interface {
+	// Model identifies the model, so that cached vectors from one model are
+	// never served for another.
+	Model() string
+	// Embed returns one vector per text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// embedder is the configured provider, or nil when text queries are
+// disabled.
+var embedder Embedder
+
+// embeddings caches embedder responses by model and text.
+var embeddings *embeddingCache
+
+// newEmbedder builds the provider selected by cfg.
+func newEmbedder(cfg EmbedderConfig) (Embedder, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "http", "openai":
+		if cfg.URL == "" {
+			return nil, errors.New("AVS_CONSOLE_EMBEDDING_URL is required for the http embedder")
+		}
+		return &httpEmbedder{url: cfg.URL, model: cfg.Model, apiKey: cfg.APIKey, client: &http.Client{}}, nil
+	case "onnx":
+		if _, err := os.Stat(cfg.ModelPath); err != nil {
+			return nil, fmt.Errorf("onnx model: %w", err)
+		}
+		return &onnxEmbedder{path: cfg.ModelPath}, nil
+	}
+	return nil, fmt.Errorf("unknown embedder %q (want http or onnx)", cfg.Provider)
+}
+
+// httpEmbedder calls an OpenAI-compatible /v1/embeddings endpoint, as served
+// by OpenAI and by local servers such as Ollama, vLLM or text-embeddings-
+// inference.
+type httpEmbedder struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+func (h *httpEmbedder) Model() string {
+	return "http:" + h.url + ":" + h.model
+}
+
+func (h *httpEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, serverConfig.timeoutFor("embed"))
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]interface{}{"model": h.model, "input": texts})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &APIError{Code: ErrCodeInternal, Message: "invalid embedding URL", Details: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, &APIError{Code: ErrCodeTimeout, Message: "embedding request timed out", Details: err.Error()}
+		}
+		return nil, &APIError{Code: ErrCodeBackend, Message: "embedding provider is unreachable", Details: err.Error()}
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, &APIError{Code: ErrCodeBackend, Message: "failed to read embedding response", Details: err.Error()}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &APIError{
+			Code:    ErrCodeBackend,
+			Message: fmt.Sprintf("embedding provider returned %s", resp.Status),
+			Details: string(bytes.TrimSpace(raw)),
+		}
+	}
+
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, &APIError{Code: ErrCodeBackend, Message: "failed to parse embedding response", Details: err.Error()}
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, newAPIError(ErrCodeBackend, "embedding provider returned %d vectors for %d texts", len(parsed.Data), len(texts))
+	}
+	vectors := make([][]float64, len(texts))
+	for _, item := range parsed.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, newAPIError(ErrCodeBackend, "embedding provider returned index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+// onnxEmbedder is a placeholder for running a local ONNX model in process.
+// This build has no ONNX runtime, so it reports text queries as unsupported
+// instead of failing at startup; use the http provider with a local
+// embedding server meanwhile.
+type onnxEmbedder struct {
+	path string
+}
+
+func (o *onnxEmbedder) Model() string {
+	return "onnx:" + o.path
+}
+
+func (o *onnxEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	return nil, &APIError{
+		Code:    ErrCodeUnsupported,
+		Message: "this build has no ONNX runtime",
+		Details: "set AVS_CONSOLE_EMBEDDER=http and point AVS_CONSOLE_EMBEDDING_URL at a local embedding server",
+	}
+}
+
+// embeddingCache is a least-recently-used cache of embeddings.
+type embeddingCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedEmbedding struct {
+	key    string
+	vector []float64
+}
+
+func newEmbeddingCache(size int) *embeddingCache {
+	return &embeddingCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *embeddingCache) get(key string) ([]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedEmbedding).vector, true
+}
+
+func (c *embeddingCache) put(key string, vector []float64) {
+	if c.size < 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cachedEmbedding).vector = vector
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedEmbedding{key: key, vector: vector})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedEmbedding).key)
+	}
+}
+
+// embedText embeds text for a search on index, checking that the model's
+// output matches the index's dimensions.
+func embedText(ctx context.Context, index IndexInfo, text string) ([]float64, error) {
+	if embedder == nil {
+		return nil, newAPIError(ErrCodeUnsupported, "text queries need an embedding provider; set AVS_CONSOLE_EMBEDDER")
+	}
+
+	key := embedder.Model() + "\x00" + text
+	vector, ok := embeddings.get(key)
+	if !ok {
+		vectors, err := embedder.Embed(ctx, []string{text})
+		if err != nil {
+			logger.Printf("Error embedding query text: %v", err)
+			return nil, err
+		}
+		vector = vectors[0]
+		embeddings.put(key, vector)
+	}
+
+	if len(vector) != index.Dimensions {
+		return nil, &APIError{
+			Code:    ErrCodeBadRequest,
+			Message: "embedding model does not match the index",
+			Details: fmt.Sprintf("model %s produces %d dimensions, index %s expects %d", embedder.Model(), len(vector), index.Name, index.Dimensions),
+		}
+	}
+	return vector, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPEmbedder(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case r.Header.Get("Authorization") != "Bearer secret":
+			http.Error(w, "no key", http.StatusUnauthorized)
+		case req.Input[0] == "garbage":
+			w.Write([]byte("not json"))
+		case req.Input[0] == "short":
+			w.Write([]byte(`{"data":[]}`))
+		default:
+			// Answer out of order, as the API allows
+			w.Write([]byte(`{"data":[{"index":1,"embedding":[3,4]},{"index":0,"embedding":[1,2]}]}`))
+		}
+	}))
+	defer server.Close()
+
+	embedder, err := newEmbedder(EmbedderConfig{Provider: "http", URL: server.URL, Model: "m", APIKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors, err := embedder.Embed(context.Background(), []string{"a", "b"})
+	if err != nil || !reflect.DeepEqual(vectors, [][]float64{{1, 2}, {3, 4}}) {
+		t.Errorf("vectors = %v, err = %v", vectors, err)
+	}
+	for _, text := range []string{"garbage", "short"} {
+		if _, err := embedder.Embed(context.Background(), []string{text}); asAPIError(err).Code != ErrCodeBackend {
+			t.Errorf("%s: err = %v", text, err)
+		}
+	}
+	unauthorized, _ := newEmbedder(EmbedderConfig{Provider: "http", URL: server.URL})
+	if _, err := unauthorized.Embed(context.Background(), []string{"a"}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestNewEmbedder(t *testing.T) {
+	if e, err := newEmbedder(EmbedderConfig{}); e != nil || err != nil {
+		t.Errorf("no provider = %v, %v", e, err)
+	}
+	for _, cfg := range []EmbedderConfig{{Provider: "http"}, {Provider: "onnx", ModelPath: "/nonexistent"}, {Provider: "other"}} {
+		if _, err := newEmbedder(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestEmbeddingCache(t *testing.T) {
+	cache := newEmbeddingCache(2)
+	cache.put("a", []float64{1})
+	cache.put("b", []float64{2})
+	cache.get("a")
+	cache.put("c", []float64{3})
+	if _, ok := cache.get("b"); ok {
+		t.Error("least recently used entry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	disabled := newEmbeddingCache(0)
+	disabled.put("a", []float64{1})
+	if _, ok := disabled.get("a"); ok {
+		t.Error("disabled cache kept an entry")
+	}
+}
+
+// countingEmbedder returns a vector of dims ones and counts its calls.
+type countingEmbedder struct {
+	dims  int
+	calls int
+}
+
+func (c *countingEmbedder) Model() string { return "counting" }
+
+func (c *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	c.calls++
+	return [][]float64{make([]float64, c.dims)}, nil
+}
+
+func TestEmbedText(t *testing.T) {
+	defer func(e Embedder, cache *embeddingCache) { embedder, embeddings = e, cache }(embedder, embeddings)
+	index := IndexInfo{Name: "idx", Dimensions: 3}
+
+	embedder = nil
+	if _, err := embedText(context.Background(), index, "hi"); asAPIError(err).Code != ErrCodeUnsupported {
+		t.Errorf("err = %v", err)
+	}
+
+	counting := &countingEmbedder{dims: 3}
+	embedder, embeddings = counting, newEmbeddingCache(10)
+	for i := 0; i < 3; i++ {
+		if vector, err := embedText(context.Background(), index, "hi"); err != nil || len(vector) != 3 {
+			t.Fatalf("vector = %v, err = %v", vector, err)
+		}
+	}
+	if counting.calls != 1 {
+		t.Errorf("embedder called %d times for one text", counting.calls)
+	}
+	if _, err := embedText(context.Background(), IndexInfo{Name: "wide", Dimensions: 4}, "hi"); asAPIError(err).Code != ErrCodeBadRequest {
+		t.Errorf("err = %v", err)
+	}
+}
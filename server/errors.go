@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// ErrorCode is a machine-readable identifier for an API error.
+type ErrorCode string
+
+const (
+	ErrCodeBadRequest         ErrorCode = "bad_request"
+	ErrCodeNotFound           ErrorCode = "not_found"
+	ErrCodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	ErrCodeUnauthenticated    ErrorCode = "unauthenticated"
+	ErrCodePermissionDenied   ErrorCode = "permission_denied"
+	ErrCodeCLINotInstalled    ErrorCode = "asvec_not_installed"
+	ErrCodeClusterUnreachable ErrorCode = "cluster_unreachable"
+	ErrCodeUnsupported        ErrorCode = "unsupported"
+	ErrCodeTimeout            ErrorCode = "timeout"
+	ErrCodeCancelled          ErrorCode = "cancelled"
+	ErrCodeBackend            ErrorCode = "backend_error"
+	ErrCodeInternal           ErrorCode = "internal"
+)
+
+// errorStatus maps each error code to its HTTP status.
+var errorStatus = map[ErrorCode]int{
+	ErrCodeBadRequest:         http.StatusBadRequest,
+	ErrCodeNotFound:           http.StatusNotFound,
+	ErrCodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	ErrCodeUnauthenticated:    http.StatusUnauthorized,
+	ErrCodePermissionDenied:   http.StatusForbidden,
+	ErrCodeCLINotInstalled:    http.StatusServiceUnavailable,
+	ErrCodeClusterUnreachable: http.StatusBadGateway,
+	ErrCodeUnsupported:        http.StatusNotImplemented,
+	ErrCodeTimeout:            http.StatusGatewayTimeout,
+	ErrCodeCancelled:          499, // client closed request
+	ErrCodeBackend:            http.StatusBadGateway,
+	ErrCodeInternal:           http.StatusInternalServerError,
+}
+
+// APIError is the single error type returned by every handler. It is encoded
+// as {"error": {...}} with the HTTP status derived from its code.
+type APIError struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	Details   string    `json:"details,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Code, e.Message, e.Details)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Status returns the HTTP status for the error's code.
+func (e *APIError) Status() int {
+	if status, ok := errorStatus[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// newAPIError builds an APIError with a formatted message.
+func newAPIError(code ErrorCode, format string, args ...interface{}) *APIError {
+	return &APIError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorResponse is the JSON body of every error response.
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// writeError writes err as a JSON error envelope. Errors that are not an
+// *APIError are reported as internal errors.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := asAPIError(err)
+	apiErr.RequestID = requestIDFrom(r.Context())
+
+	logger.Printf("Request %s failed: %v", apiErr.RequestID, apiErr)
+	recentErrors.Record(r, apiErr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status())
+	if err := json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr}); err != nil {
+		logger.Printf("Error encoding response: %v", err)
+	}
+}
+
+// asAPIError returns err as an APIError, wrapping unclassified errors as
+// internal ones.
+func asAPIError(err error) *APIError {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = &APIError{Code: ErrCodeInternal, Message: "internal server error", Details: err.Error()}
+	}
+	return apiErr
+}
+
+// backendError classifies an error returned by an asvec call. action
+// describes what was being attempted, e.g. "list indexes".
+func backendError(action string, err error) *APIError {
+	var stderr string
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		stderr = strings.TrimSpace(string(exitErr.Stderr))
+	}
+	lower := strings.ToLower(stderr)
+
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return &APIError{Code: ErrCodeCLINotInstalled, Message: "asvec CLI is not installed or not on PATH", Details: "https://github.com/aerospike/asvec"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Code: ErrCodeTimeout, Message: fmt.Sprintf("timed out trying to %s", action), Details: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &APIError{Code: ErrCodeCancelled, Message: fmt.Sprintf("request to %s was cancelled", action)}
+	case strings.Contains(stderr, "Unimplemented") || strings.Contains(lower, "unknown command"):
+		return &APIError{Code: ErrCodeUnsupported, Message: fmt.Sprintf("cluster does not support the request to %s", action), Details: stderr}
+	case strings.Contains(stderr, "NotFound") || strings.Contains(lower, "not found"):
+		return &APIError{Code: ErrCodeNotFound, Message: fmt.Sprintf("nothing found trying to %s", action), Details: stderr}
+	case strings.Contains(stderr, "Unauthenticated") || strings.Contains(lower, "authentication failed") || strings.Contains(lower, "invalid credentials"):
+		return &APIError{Code: ErrCodeUnauthenticated, Message: "cluster rejected the configured credentials", Details: stderr}
+	case strings.Contains(stderr, "PermissionDenied") || strings.Contains(lower, "permission denied"):
+		return &APIError{Code: ErrCodePermissionDenied, Message: fmt.Sprintf("configured user is not allowed to %s", action), Details: stderr}
+	case strings.Contains(stderr, "Unavailable") || strings.Contains(lower, "connection refused") ||
+		strings.Contains(lower, "failed to connect") || strings.Contains(lower, "no such host") ||
+		strings.Contains(lower, "deadline exceeded"):
+		return &APIError{Code: ErrCodeClusterUnreachable, Message: "unable to reach the AVS cluster", Details: stderr}
+	}
+
+	details := stderr
+	if details == "" {
+		details = err.Error()
+	}
+	return &APIError{Code: ErrCodeBackend, Message: fmt.Sprintf("failed to %s", action), Details: details}
+}
+
+type requestIDKey struct{}
+
+// withRequestID assigns each request an ID, taken from the X-Request-ID
+// header when the caller supplies one, and echoes it in the response.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestBackendError(t *testing.T) {
+	failing := func(stderr string) error {
+		_, err := exec.Command("sh", "-c", "echo '"+stderr+"' >&2; exit 1").Output()
+		return err
+	}
+	tests := []struct {
+		name string
+		err  error
+		code ErrorCode
+	}{
+		{"not installed", &exec.Error{Name: "asvec", Err: exec.ErrNotFound}, ErrCodeCLINotInstalled},
+		{"timeout", fmt.Errorf("asvec ls timed out: %w", context.DeadlineExceeded), ErrCodeTimeout},
+		{"cancelled", context.Canceled, ErrCodeCancelled},
+		{"unimplemented", failing("rpc error: code = Unimplemented"), ErrCodeUnsupported},
+		{"unknown command", failing("Error: unknown command \"scan\""), ErrCodeUnsupported},
+		{"not found", failing("index idx not found"), ErrCodeNotFound},
+		{"unauthenticated", failing("rpc error: code = Unauthenticated"), ErrCodeUnauthenticated},
+		{"permission denied", failing("rpc error: code = PermissionDenied"), ErrCodePermissionDenied},
+		{"unreachable", failing("dial tcp: connection refused"), ErrCodeClusterUnreachable},
+		{"other failure", failing("something broke"), ErrCodeBackend},
+		{"no stderr", errors.New("exit status 2"), ErrCodeBackend},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := backendError("list indexes", tt.err)
+			if apiErr.Code != tt.code {
+				t.Errorf("code = %s, want %s (%+v)", apiErr.Code, tt.code, apiErr)
+			}
+			if apiErr.Status() < 400 {
+				t.Errorf("status = %d", apiErr.Status())
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{newAPIError(ErrCodeBadRequest, "bad"), http.StatusBadRequest, ErrCodeBadRequest},
+		{fmt.Errorf("wrapped: %w", newAPIError(ErrCodeNotFound, "gone")), http.StatusNotFound, ErrCodeNotFound},
+		{errors.New("plain"), http.StatusInternalServerError, ErrCodeInternal},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		withRequestID(func(w http.ResponseWriter, r *http.Request) { writeError(w, r, tt.err) })(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%v: status = %d, want %d", tt.err, rec.Code, tt.status)
+		}
+		id := rec.Header().Get("X-Request-ID")
+		want := fmt.Sprintf(`"code":%q`, tt.code)
+		if body := rec.Body.String(); id == "" || !strings.Contains(body, want) || !strings.Contains(body, id) {
+			t.Errorf("%v: body = %s, request ID %q", tt.err, body, id)
+		}
+	}
+}
@@ -1,5 +1,5 @@
 module server
 
-go 1.21.1
+go 1.22
 
 require gopkg.in/yaml.v3 v3.0.1
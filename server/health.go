@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Finding severities, from least to most severe.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityRank = map[string]int{"": 0, SeverityInfo: 1, SeverityWarning: 2, SeverityCritical: 3}
+
+// Thresholds used by the health rules.
+const (
+	unmergedWarningPercent  = 10
+	unmergedCriticalPercent = 30
+	verticesWarningRatio    = 0.9
+	verticesCriticalRatio   = 0.5
+	// unmergedGrowthSamples consecutive increases of the unmerged count,
+	// sampled at least unmergedSampleInterval apart, make a growing backlog.
+	unmergedGrowthSamples  = 3
+	unmergedSampleInterval = time.Minute
+	maxUnmergedSamples     = 20
+	unmergedSampleWindow   = time.Hour
+)
+
+// HealthFinding is one problem found with an index.
+type HealthFinding struct {
+	Rule       string `json:"rule"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// IndexHealth holds the findings for one index. Status is "healthy" or the
+// severity of the worst finding.
+type IndexHealth struct {
+	Index     string          `json:"index"`
+	Status    string          `json:"status"`
+	Findings  []HealthFinding `json:"findings"`
+	CheckedAt time.Time       `json:"checkedAt"`
+}
+
+// healthRule inspects an index. trend holds recent unmerged counts of the
+// index, oldest first.
+type healthRule struct {
+	ID    string
+	Check func(index IndexInfo, trend []unmergedSample) []HealthFinding
+}
+
+// healthRules are evaluated in order for every index.
+var healthRules = []healthRule{
+	{ID: "index-status", Check: checkIndexStatus},
+	{ID: "unmerged-backlog", Check: checkUnmergedBacklog},
+	{ID: "unmerged-growing", Check: checkUnmergedGrowth},
+	{ID: "vertices-low", Check: checkVertices},
+	{ID: "healer-disabled", Check: checkHealer},
+	{ID: "profile-mismatch", Check: checkProfile},
+}
+
+type unmergedSample struct {
+	Time     time.Time
+	Unmerged int
+}
+
+// unmergedTrend remembers the unmerged count of each index when it is
+// analyzed, so that a growing backlog can be told from a steady one.
+var unmergedTrend = &unmergedTracker{samples: map[string][]unmergedSample{}}
+
+type unmergedTracker struct {
+	mu      sync.Mutex
+	samples map[string][]unmergedSample
+}
+
+// observe records the current unmerged count of an index and returns the
+// samples of the last hour. A count read within unmergedSampleInterval of
+// the last sample is not recorded, so that frequent polling does not turn
+// noise into a trend.
+func (t *unmergedTracker) observe(index IndexInfo, now time.Time) []unmergedSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var kept []unmergedSample
+	for _, sample := range t.samples[index.Name] {
+		if now.Sub(sample.Time) <= unmergedSampleWindow {
+			kept = append(kept, sample)
+		}
+	}
+	if len(kept) == 0 || now.Sub(kept[len(kept)-1].Time) >= unmergedSampleInterval {
+		kept = append(kept, unmergedSample{Time: now, Unmerged: index.Unmerged})
+	}
+	if len(kept) > maxUnmergedSamples {
+		kept = kept[len(kept)-maxUnmergedSamples:]
+	}
+	t.samples[index.Name] = kept
+	return append([]unmergedSample(nil), kept...)
+}
+
+// analyzeIndex runs every rule against an index.
+func analyzeIndex(index IndexInfo, trend []unmergedSample, now time.Time) IndexHealth {
+	health := IndexHealth{Index: index.Name, Status: "healthy", Findings: []HealthFinding{}, CheckedAt: now}
+	worst := ""
+	for _, rule := range healthRules {
+		for _, finding := range rule.Check(index, trend) {
+			finding.Rule = rule.ID
+			health.Findings = append(health.Findings, finding)
+			if severityRank[finding.Severity] > severityRank[worst] {
+				worst = finding.Severity
+			}
+		}
+	}
+	if worst != "" {
+		health.Status = worst
+	}
+	return health
+}
+
+func checkIndexStatus(index IndexInfo, trend []unmergedSample) []HealthFinding {
+	if index.Status == "" || strings.EqualFold(index.Status, "READY") {
+		return nil
+	}
+	return []HealthFinding{{
+		Severity:   SeverityWarning,
+		Message:    fmt.Sprintf("index status is %s", index.Status),
+		Suggestion: "searches may be incomplete until the index is READY; check the AVS node logs if it stays in this state",
+	}}
+}
+
+// unmergedPercent reads the percentage asvec reports, falling back to
+// computing it from the counts.
+func unmergedPercent(index IndexInfo) float64 {
+	if value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(index.UnmergedPercent, "%")), 64); err == nil {
+		return value
+	}
+	if index.VectorRecords == 0 {
+		return 0
+	}
+	return float64(index.Unmerged) / float64(index.VectorRecords) * 100
+}
+
+func checkUnmergedBacklog(index IndexInfo, trend []unmergedSample) []HealthFinding {
+	percent := unmergedPercent(index)
+	severity := ""
+	switch {
+	case percent >= unmergedCriticalPercent:
+		severity = SeverityCritical
+	case percent >= unmergedWarningPercent:
+		severity = SeverityWarning
+	default:
+		return nil
+	}
+	return []HealthFinding{{
+		Severity:   severity,
+		Message:    fmt.Sprintf("%.1f%% of records (%d) are not merged into the index yet", percent, index.Unmerged),
+		Suggestion: "recent writes are not searchable until merged; slow ingestion down or raise hnsw-merge-parallelism and hnsw-batch-max-index-records",
+	}}
+}
+
+func checkUnmergedGrowth(index IndexInfo, trend []unmergedSample) []HealthFinding {
+	if len(trend) < unmergedGrowthSamples {
+		return nil
+	}
+	recent := trend[len(trend)-unmergedGrowthSamples:]
+	for i := 1; i < len(recent); i++ {
+		if recent[i].Unmerged <= recent[i-1].Unmerged {
+			return nil
+		}
+	}
+	first, last := recent[0], recent[len(recent)-1]
+	return []HealthFinding{{
+		Severity: SeverityWarning,
+		Message: fmt.Sprintf("unmerged backlog grew from %d to %d over %s",
+			first.Unmerged, last.Unmerged, last.Time.Sub(first.Time).Round(time.Second)),
+		Suggestion: "indexing is falling behind writes; check indexer node CPU and raise hnsw-merge-parallelism, or add indexer nodes",
+	}}
+}
+
+func checkVertices(index IndexInfo, trend []unmergedSample) []HealthFinding {
+	if index.VectorRecords == 0 {
+		return nil
+	}
+	ratio := float64(index.Vertices) / float64(index.VectorRecords)
+	severity := ""
+	switch {
+	case ratio < verticesCriticalRatio:
+		severity = SeverityCritical
+	case ratio < verticesWarningRatio:
+		severity = SeverityWarning
+	default:
+		return nil
+	}
+	return []HealthFinding{{
+		Severity:   severity,
+		Message:    fmt.Sprintf("the graph has %d vertices for %d records (%.0f%%)", index.Vertices, index.VectorRecords, ratio*100),
+		Suggestion: "records are missing from the graph; make sure the healer is scheduled and let it run, and check for an unmerged backlog",
+	}}
+}
+
+// disabledValues are parameter values that turn a healer setting off.
+var disabledValues = map[string]bool{"false": true, "disabled": true, "off": true, "never": true, "none": true}
+
+func checkHealer(index IndexInfo, trend []unmergedSample) []HealthFinding {
+	for key, value := range normalizeParameters(index.Parameters) {
+		if !strings.Contains(key, "healer") {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+		if (strings.HasSuffix(key, "disabled") && value == "true") ||
+			(strings.HasSuffix(key, "schedule") && (value == "" || disabledValues[value])) ||
+			(strings.HasSuffix(key, "enabled") && disabledValues[value]) {
+			return []HealthFinding{{
+				Severity:   SeverityWarning,
+				Message:    fmt.Sprintf("the healer is disabled (%s = %q)", key, value),
+				Suggestion: "without the healer, records that failed to index are never repaired; set hnsw-healer-schedule, e.g. \"0 0/15 * ? * * *\"",
+			}}
+		}
+	}
+	return nil
+}
+
+func checkProfile(index IndexInfo, trend []unmergedSample) []HealthFinding {
+	params := normalizeParameters(index.Parameters)
+	var findings []HealthFinding
+	for _, key := range sortedKeys(serverConfig.IndexProfile) {
+		want := serverConfig.IndexProfile[key]
+		got, ok := params[key]
+		if !ok || got == want {
+			continue
+		}
+		suggestion := fmt.Sprintf("set %s to %s with POST /api/v1/schema/apply", key, want)
+		if immutableParameters[key] {
+			suggestion = fmt.Sprintf("%s is fixed when the index is built; recreate the index with %s to adopt the profile", key, want)
+		}
+		findings = append(findings, HealthFinding{
+			Severity:   SeverityInfo,
+			Message:    fmt.Sprintf("%s is %s, the recommended profile uses %s", key, got, want),
+			Suggestion: suggestion,
+		})
+	}
+	return findings
+}
+
+// getIndexesHealth analyzes every index.
+func getIndexesHealth(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling index health request from %s", r.RemoteAddr)
+
+	indexes, err := listIndexes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	now := time.Now().UTC()
+	report := make([]IndexHealth, len(indexes))
+	for i, index := range indexes {
+		report[i] = analyzeIndex(index, unmergedTrend.observe(index, now), now)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// getIndexHealth analyzes one index.
+func getIndexHealth(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling index health request from %s", r.RemoteAddr)
+
+	index, err := findIndex(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	now := time.Now().UTC()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analyzeIndex(index, unmergedTrend.observe(index, now), now))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnmergedTrackerSamplesOnAnInterval(t *testing.T) {
+	tracker := &unmergedTracker{samples: map[string][]unmergedSample{}}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	observe := func(after time.Duration, unmerged int) []unmergedSample {
+		return tracker.observe(IndexInfo{Name: "idx", Unmerged: unmerged}, start.Add(after))
+	}
+	counts := func(samples []unmergedSample) []int {
+		var list []int
+		for _, sample := range samples {
+			list = append(list, sample.Unmerged)
+		}
+		return list
+	}
+
+	// Quick polls keep only the first reading
+	observe(0, 10)
+	observe(time.Second, 20)
+	if got := counts(observe(2*time.Second, 30)); !reflect.DeepEqual(got, []int{10}) {
+		t.Errorf("samples = %v", got)
+	}
+	observe(time.Minute, 20)
+	if got := counts(observe(2*time.Minute, 30)); !reflect.DeepEqual(got, []int{10, 20, 30}) {
+		t.Errorf("samples = %v", got)
+	}
+	// Samples older than the window are dropped
+	if got := counts(observe(time.Hour+90*time.Second, 40)); !reflect.DeepEqual(got, []int{30, 40}) {
+		t.Errorf("samples = %v", got)
+	}
+}
+
+func TestAnalyzeIndex(t *testing.T) {
+	defer func(profile map[string]string) { serverConfig.IndexProfile = profile }(serverConfig.IndexProfile)
+	serverConfig.IndexProfile = map[string]string{"hnsw-m": "16", "hnsw-ef": "100"}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	healthy := IndexInfo{Name: "idx", Status: "READY", VectorRecords: 1000, Vertices: 1000, Unmerged: 10,
+		Parameters: map[string]string{"m": "16", "ef": "100", "healer-schedule": "0 0/15 * ? * * *"}}
+	with := func(edit func(*IndexInfo)) IndexInfo {
+		index := healthy
+		index.Parameters = map[string]string{}
+		for k, v := range healthy.Parameters {
+			index.Parameters[k] = v
+		}
+		edit(&index)
+		return index
+	}
+	trend := func(counts ...int) []unmergedSample {
+		var samples []unmergedSample
+		for i, count := range counts {
+			samples = append(samples, unmergedSample{Time: now.Add(time.Duration(i-len(counts)+1) * time.Minute), Unmerged: count})
+		}
+		return samples
+	}
+	tests := []struct {
+		name     string
+		index    IndexInfo
+		trend    []unmergedSample
+		status   string
+		findings []string // "rule severity"
+	}{
+		{"healthy", healthy, trend(5, 5, 10), "healthy", nil},
+		{"not ready", with(func(i *IndexInfo) { i.Status = "BUILDING" }), nil, SeverityWarning,
+			[]string{"index-status warning"}},
+		{"no status printed", with(func(i *IndexInfo) { i.Status = "" }), nil, "healthy", nil},
+		{"unmerged warning", with(func(i *IndexInfo) { i.UnmergedPercent = "12.5%" }), nil, SeverityWarning,
+			[]string{"unmerged-backlog warning"}},
+		{"unmerged critical from counts", with(func(i *IndexInfo) { i.Unmerged = 300 }), nil, SeverityCritical,
+			[]string{"unmerged-backlog critical"}},
+		{"unmerged growing", healthy, trend(1, 4, 6, 8), SeverityWarning,
+			[]string{"unmerged-growing warning"}},
+		{"unmerged growth too short", healthy, trend(4, 8), "healthy", nil},
+		{"unmerged not steadily growing", healthy, trend(4, 8, 8), "healthy", nil},
+		{"vertices low", with(func(i *IndexInfo) { i.Vertices = 800 }), nil, SeverityWarning,
+			[]string{"vertices-low warning"}},
+		{"vertices critical", with(func(i *IndexInfo) { i.Vertices = 100 }), nil, SeverityCritical,
+			[]string{"vertices-low critical"}},
+		{"healer schedule disabled", with(func(i *IndexInfo) { i.Parameters["healer-schedule"] = "never" }), nil, SeverityWarning,
+			[]string{"healer-disabled warning"}},
+		{"healer disabled flag", with(func(i *IndexInfo) { i.Parameters["hnsw-healer-disabled"] = "true" }), nil, SeverityWarning,
+			[]string{"healer-disabled warning"}},
+		{"profile mismatch", with(func(i *IndexInfo) { i.Parameters["m"] = "32"; i.Parameters["ef"] = "200" }), nil, SeverityInfo,
+			[]string{"profile-mismatch info", "profile-mismatch info"}},
+		{"worst finding wins", with(func(i *IndexInfo) { i.Status = "BUILDING"; i.Vertices = 0; i.Parameters["ef"] = "50" }), nil, SeverityCritical,
+			[]string{"index-status warning", "vertices-low critical", "profile-mismatch info"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := analyzeIndex(tt.index, tt.trend, now)
+			var findings []string
+			for _, finding := range health.Findings {
+				findings = append(findings, finding.Rule+" "+finding.Severity)
+				if finding.Message == "" || finding.Suggestion == "" {
+					t.Errorf("finding = %+v", finding)
+				}
+			}
+			if health.Status != tt.status || !reflect.DeepEqual(findings, tt.findings) {
+				t.Errorf("health = %s %q, want %s %q", health.Status, findings, tt.status, tt.findings)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anonymousUser owns the history of requests without a user header.
+const anonymousUser = "anonymous"
+
+// maxSavedQueries bounds how many saved queries are kept across all users.
+const maxSavedQueries = 1000
+
+// HistoryEntry records one /api/query call. The query vector itself is not
+// kept; VectorHash tells calls with the same vector apart.
+type HistoryEntry struct {
+	ID            string                 `json:"id"`
+	Index         string                 `json:"index"`
+	VectorHash    string                 `json:"vectorHash,omitempty"`
+	Key           string                 `json:"key,omitempty"`
+	Set           string                 `json:"set,omitempty"`
+	Text          string                 `json:"text,omitempty"`
+	Limit         int                    `json:"limit"`
+	Ef            int                    `json:"ef,omitempty"`
+	Filter        map[string]interface{} `json:"filter,omitempty"`
+	ExecutionTime float64                `json:"executionTime"`
+	ResultIDs     []string               `json:"resultIds"`
+	Error         string                 `json:"error,omitempty"`
+	// SavedQuery is set when the call re-ran a saved query.
+	SavedQuery string    `json:"savedQuery,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// SavedQuery is a named query that can be re-run by ID. Any user holding
+// the ID can read and run it, so the ID doubles as a shareable link.
+type SavedQuery struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Owner     string         `json:"owner"`
+	Query     QueryRequest   `json:"query"`
+	CreatedAt time.Time      `json:"createdAt"`
+	LastRun   *SavedQueryRun `json:"lastRun,omitempty"`
+}
+
+// SavedQueryRequest names a query to save.
+type SavedQueryRequest struct {
+	Name  string       `json:"name"`
+	Query QueryRequest `json:"query"`
+}
+
+// SavedQueryRun is the outcome of the latest run of a saved query.
+type SavedQueryRun struct {
+	Time          time.Time `json:"time"`
+	ResultIDs     []string  `json:"resultIds"`
+	ExecutionTime float64   `json:"executionTime"`
+}
+
+// RankMove is a record found by both runs at different ranks. Ranks are
+// 1-based.
+type RankMove struct {
+	ID   string `json:"id"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// ResultDiff compares a run of a saved query with the run before it.
+type ResultDiff struct {
+	// Previous is when the compared run happened; it is absent on the
+	// first run, which has nothing to compare with.
+	Previous  *time.Time `json:"previous,omitempty"`
+	Added     []string   `json:"added"`
+	Removed   []string   `json:"removed"`
+	Moved     []RankMove `json:"moved"`
+	Unchanged bool       `json:"unchanged"`
+}
+
+// SavedQueryRunResponse holds the results of re-running a saved query.
+type SavedQueryRunResponse struct {
+	SavedQuery    SavedQuery    `json:"savedQuery"`
+	Results       []QueryResult `json:"results"`
+	ExecutionTime float64       `json:"executionTime"`
+	Diff          ResultDiff    `json:"diff"`
+}
+
+// queries keeps query history and saved queries.
+var queries *queryStore
+
+// queryStore persists per-user query history and saved queries as JSON
+// files in the data directory.
+type queryStore struct {
+	historyPath string
+	savedPath   string
+	historySize int
+
+	mu      sync.Mutex
+	history map[string][]HistoryEntry
+	saved   []SavedQuery
+	stopped bool
+
+	// historySaves wakes the goroutine that writes the history file, so
+	// that recording a query never waits for the disk. saveMu serializes
+	// writes of the file.
+	historySaves chan struct{}
+	historyDone  chan struct{}
+	saveMu       sync.Mutex
+}
+
+func newQueryStore(dir string, historySize int) *queryStore {
+	store := &queryStore{
+		historyPath:  filepath.Join(dir, "query-history.json"),
+		savedPath:    filepath.Join(dir, "saved-queries.json"),
+		historySize:  historySize,
+		history:      map[string][]HistoryEntry{},
+		historySaves: make(chan struct{}, 1),
+		historyDone:  make(chan struct{}),
+	}
+	if err := readJSONFile(store.historyPath, &store.history); err != nil {
+		logger.Printf("Error loading query history: %v", err)
+	}
+	if err := readJSONFile(store.savedPath, &store.saved); err != nil {
+		logger.Printf("Error loading saved queries: %v", err)
+	}
+	go store.saveHistoryLoop()
+	return store
+}
+
+// Record appends an entry to a user's history, dropping the oldest entries
+// beyond the configured size. The history file is saved in the background.
+func (s *queryStore) Record(user string, entry HistoryEntry) {
+	if s.historySize < 1 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := append(s.history[user], entry)
+	if len(entries) > s.historySize {
+		entries = entries[len(entries)-s.historySize:]
+	}
+	s.history[user] = entries
+	if !s.stopped {
+		// A save already pending will pick this entry up
+		select {
+		case s.historySaves <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *queryStore) saveHistoryLoop() {
+	defer close(s.historyDone)
+	for range s.historySaves {
+		if err := s.saveHistory(); err != nil {
+			logger.Printf("Error saving query history: %v", err)
+		}
+	}
+}
+
+// saveHistory writes the history file from a snapshot taken under the store
+// mutex, so that queries are recorded while the file is written. Entries
+// are only ever appended or dropped from the front, so the snapshot may
+// share them.
+func (s *queryStore) saveHistory() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	snapshot := make(map[string][]HistoryEntry, len(s.history))
+	for user, entries := range s.history {
+		snapshot[user] = entries
+	}
+	s.mu.Unlock()
+	return writeJSONFile(s.historyPath, snapshot)
+}
+
+// Stop saves any history not yet written and stops the background saver.
+// Queries recorded afterwards are kept in memory only.
+func (s *queryStore) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.historySaves)
+	s.mu.Unlock()
+	<-s.historyDone
+	if err := s.saveHistory(); err != nil {
+		logger.Printf("Error saving query history: %v", err)
+	}
+}
+
+// History returns a user's entries, optionally for one index, newest first.
+func (s *queryStore) History(user, index string, limit int) []HistoryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []HistoryEntry{}
+	entries := s.history[user]
+	for i := len(entries) - 1; i >= 0 && (limit < 1 || len(list) < limit); i-- {
+		if index == "" || entries[i].Index == index {
+			list = append(list, entries[i])
+		}
+	}
+	return list
+}
+
+func (s *queryStore) ClearHistory(user string) error {
+	s.mu.Lock()
+	delete(s.history, user)
+	s.mu.Unlock()
+	return s.saveHistory()
+}
+
+func (s *queryStore) Save(query SavedQuery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.saved) >= maxSavedQueries {
+		return newAPIError(ErrCodeBadRequest, "at most %d saved queries are kept; delete some first", maxSavedQueries)
+	}
+	s.saved = append(s.saved, query)
+	return writeJSONFile(s.savedPath, s.saved)
+}
+
+func (s *queryStore) Saved(id string) (SavedQuery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, query := range s.saved {
+		if query.ID == id {
+			return query, nil
+		}
+	}
+	return SavedQuery{}, newAPIError(ErrCodeNotFound, "saved query %q not found", id)
+}
+
+// ListSaved returns a user's saved queries in the order they were saved.
+func (s *queryStore) ListSaved(user string) []SavedQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []SavedQuery{}
+	for _, query := range s.saved {
+		if query.Owner == user {
+			list = append(list, query)
+		}
+	}
+	return list
+}
+
+// DeleteSaved removes a saved query. Only its owner may delete it.
+func (s *queryStore) DeleteSaved(user, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, query := range s.saved {
+		if query.ID != id {
+			continue
+		}
+		if query.Owner != user {
+			return newAPIError(ErrCodePermissionDenied, "saved query %q belongs to %s", id, query.Owner)
+		}
+		s.saved = append(s.saved[:i], s.saved[i+1:]...)
+		return writeJSONFile(s.savedPath, s.saved)
+	}
+	return newAPIError(ErrCodeNotFound, "saved query %q not found", id)
+}
+
+// SetLastRun replaces the last run of a saved query and returns the run it
+// replaced, if any.
+func (s *queryStore) SetLastRun(id string, run SavedQueryRun) (*SavedQueryRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.saved {
+		if s.saved[i].ID == id {
+			previous := s.saved[i].LastRun
+			s.saved[i].LastRun = &run
+			return previous, writeJSONFile(s.savedPath, s.saved)
+		}
+	}
+	return nil, newAPIError(ErrCodeNotFound, "saved query %q not found", id)
+}
+
+// requestUser names the user making a request, from the header configured
+// in AVS_CONSOLE_USER_HEADER.
+func requestUser(r *http.Request) string {
+	if user := strings.TrimSpace(r.Header.Get(serverConfig.UserHeader)); user != "" {
+		return user
+	}
+	return anonymousUser
+}
+
+// vectorHash identifies a query vector without storing it.
+func vectorHash(vector []float64) string {
+	if len(vector) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(vector)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+func resultIDs(results []QueryResult) []string {
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids
+}
+
+// recordQuery adds a query call to the requesting user's history.
+func recordQuery(r *http.Request, query QueryRequest, results []QueryResult, elapsed time.Duration, err error, savedQuery string) {
+	entry := HistoryEntry{
+		ID:            newRequestID(),
+		Index:         query.Index,
+		VectorHash:    vectorHash(query.Query),
+		Key:           query.Key,
+		Set:           query.Set,
+		Text:          query.Text,
+		Limit:         query.Limit,
+		Ef:            query.Ef,
+		Filter:        query.Filter,
+		ExecutionTime: elapsed.Seconds(),
+		ResultIDs:     resultIDs(results),
+		SavedQuery:    savedQuery,
+		Time:          time.Now().UTC(),
+	}
+	if entry.Limit < 1 {
+		entry.Limit = 10
+	}
+	if err != nil {
+		entry.Error = asAPIError(err).Message
+	}
+	queries.Record(requestUser(r), entry)
+}
+
+// diffResults compares the result IDs of two runs.
+func diffResults(previous, current []string) ResultDiff {
+	diff := ResultDiff{Added: []string{}, Removed: []string{}, Moved: []RankMove{}}
+	before := make(map[string]int, len(previous))
+	for i, id := range previous {
+		before[id] = i + 1
+	}
+	after := make(map[string]bool, len(current))
+	for i, id := range current {
+		after[id] = true
+		rank, ok := before[id]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, id)
+		case rank != i+1:
+			diff.Moved = append(diff.Moved, RankMove{ID: id, From: rank, To: i + 1})
+		}
+	}
+	for _, id := range previous {
+		if !after[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	diff.Unchanged = len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Moved) == 0
+	return diff
+}
+
+// getQueryHistory lists the requesting user's queries, newest first,
+// optionally for one ?index= and at most ?limit= entries.
+func getQueryHistory(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, r, newAPIError(ErrCodeBadRequest, "limit must be a positive integer"))
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queries.History(requestUser(r), r.URL.Query().Get("index"), limit))
+}
+
+func clearQueryHistory(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if err := queries.ClearHistory(requestUser(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listSavedQueries(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queries.ListSaved(requestUser(r)))
+}
+
+func createSavedQuery(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling save query request from %s", r.RemoteAddr)
+
+	var req SavedQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" || req.Query.Index == "" {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "a saved query needs a name and an index"))
+		return
+	}
+	if len(req.Query.Query) == 0 && req.Query.Key == "" && req.Query.Text == "" {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "a saved query needs a query vector, a record key or text"))
+		return
+	}
+
+	saved := SavedQuery{
+		ID:        newRequestID(),
+		Name:      strings.TrimSpace(req.Name),
+		Owner:     requestUser(r),
+		Query:     req.Query,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := queries.Save(saved); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/saved-queries/"+saved.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func getSavedQuery(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	saved, err := queries.Saved(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func deleteSavedQuery(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	if err := queries.DeleteSaved(requestUser(r), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runSavedQuery re-runs a saved query and diffs its results with the
+// previous run.
+func runSavedQuery(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling saved query run request from %s", r.RemoteAddr)
+
+	saved, err := queries.Saved(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	results, elapsed, err := runQuery(r.Context(), runShared, saved.Query)
+	recordQuery(r, saved.Query, results, elapsed, err, saved.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	run := SavedQueryRun{Time: time.Now().UTC(), ResultIDs: resultIDs(results), ExecutionTime: elapsed.Seconds()}
+	previous, err := queries.SetLastRun(saved.ID, run)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	response := SavedQueryRunResponse{Results: results, ExecutionTime: run.ExecutionTime}
+	if previous != nil {
+		response.Diff = diffResults(previous.ResultIDs, run.ResultIDs)
+		response.Diff.Previous = &previous.Time
+	} else {
+		response.Diff = diffResults(run.ResultIDs, run.ResultIDs)
+	}
+	saved.LastRun = &run
+	response.SavedQuery = saved
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestQueryHistorySavedInBackground(t *testing.T) {
+	dir := t.TempDir()
+	store := newQueryStore(dir, 3)
+
+	var wg sync.WaitGroup
+	for _, user := range []string{"ann", "bob"} {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			for i := 1; i <= 50; i++ {
+				index := "a"
+				if i%2 == 0 {
+					index = "b"
+				}
+				store.Record(user, HistoryEntry{ID: fmt.Sprintf("%s-%d", user, i), Index: index})
+			}
+		}(user)
+	}
+	wg.Wait()
+	store.Stop()
+
+	ids := func(entries []HistoryEntry) []string {
+		var list []string
+		for _, entry := range entries {
+			list = append(list, entry.ID)
+		}
+		return list
+	}
+	// Only the newest entries are kept, and they survive a restart
+	reloaded := newQueryStore(dir, 3)
+	defer reloaded.Stop()
+	if got := ids(reloaded.History("ann", "", 0)); !reflect.DeepEqual(got, []string{"ann-50", "ann-49", "ann-48"}) {
+		t.Errorf("history = %q", got)
+	}
+	if got := ids(reloaded.History("bob", "a", 0)); !reflect.DeepEqual(got, []string{"bob-49"}) {
+		t.Errorf("history for index a = %q", got)
+	}
+	if got := ids(reloaded.History("bob", "", 1)); !reflect.DeepEqual(got, []string{"bob-50"}) {
+		t.Errorf("limited history = %q", got)
+	}
+
+	if err := reloaded.ClearHistory("ann"); err != nil {
+		t.Fatal(err)
+	}
+	if got := newQueryStore(dir, 3).History("ann", "", 0); len(got) != 0 {
+		t.Errorf("cleared history = %+v", got)
+	}
+
+	// Recording after Stop keeps the entry in memory without saving it
+	store.Record("carol", HistoryEntry{ID: "late"})
+	if got := ids(store.History("carol", "", 0)); !reflect.DeepEqual(got, []string{"late"}) {
+		t.Errorf("history = %q", got)
+	}
+}
+
+func TestQueryHistoryDisabled(t *testing.T) {
+	dir := t.TempDir()
+	store := newQueryStore(dir, 0)
+	store.Record("ann", HistoryEntry{ID: "1"})
+	store.Stop()
+	if got := store.History("ann", "", 0); len(got) != 0 {
+		t.Errorf("history = %+v", got)
+	}
+	if got := newQueryStore(dir, 10).History("ann", "", 0); len(got) != 0 {
+		t.Errorf("saved history = %+v", got)
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	diff := diffResults([]string{"a", "b", "c", "d"}, []string{"b", "a", "c", "e"})
+	want := ResultDiff{
+		Added:   []string{"e"},
+		Removed: []string{"d"},
+		Moved:   []RankMove{{ID: "b", From: 2, To: 1}, {ID: "a", From: 1, To: 2}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %+v, want %+v", diff, want)
+	}
+	if same := diffResults([]string{"a", "b"}, []string{"a", "b"}); !same.Unchanged || len(same.Moved) != 0 {
+		t.Errorf("diff = %+v", same)
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// maxJobLogs bounds the log lines kept per job; older lines are dropped.
+const maxJobLogs = 200
+
+// maxStoredJobs bounds how many finished jobs are kept.
+const maxStoredJobs = 200
+
+// JobStatus is the lifecycle state of a job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// JobRequest submits a job.
+type JobRequest struct {
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// JobLog is a timestamped line of a job's log.
+type JobLog struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Job is a long-running operation and its progress.
+type Job struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Status JobStatus              `json:"status"`
+	Params map[string]interface{} `json:"params"`
+	// Progress is the completed percentage, 0 to 100. It is an estimate
+	// while the job runs.
+	Progress float64 `json:"progress"`
+	// Processed counts the items handled so far, in Unit.
+	Processed int64  `json:"processed"`
+	Unit      string `json:"unit"`
+	// Throughput is in Unit per second.
+	Throughput float64 `json:"throughput"`
+	// ETASeconds estimates the time left; it is omitted when unknown.
+	ETASeconds float64     `json:"etaSeconds,omitempty"`
+	Logs       []JobLog    `json:"logs"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      *APIError   `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// jobType describes how jobs of one type are validated and run.
+type jobType struct {
+	// Unit names what Processed counts, e.g. "rows".
+	Unit string
+	// Concurrency is the default number of jobs of this type run at once.
+	Concurrency int
+	// Validate checks the params of a submitted job. It may be nil.
+	Validate func(params map[string]interface{}) error
+	// Run performs the job and returns its result.
+	Run func(ctx context.Context, job *jobHandle) (interface{}, error)
+}
+
+// jobTypes lists the kinds of job the manager can run.
+var jobTypes = map[string]jobType{
+	// Benchmarks run one at a time so that they do not skew each other
+	"benchmark": {Unit: "searches", Concurrency: 1, Validate: validateBenchmarkJob, Run: runBenchmarkJob},
+}
+
+// jobs runs every job submitted to the server.
+var jobs *jobManager
+
+// jobManager schedules jobs with a bounded concurrency per type and saves
+// their state to dir so that it survives a restart. Jobs interrupted by a
+// shutdown are queued again when the server next starts.
+type jobManager struct {
+	dir    string
+	limits map[string]int
+
+	// ctx is cancelled by Stop to interrupt running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	jobs     map[string]*jobState
+	order    []string
+	sem      map[string]chan struct{}
+	lastSave time.Time
+}
+
+type jobState struct {
+	job             Job
+	cancel          context.CancelFunc
+	cancelRequested bool
+}
+
+// jobHandle is passed to a running job to report progress and log lines.
+type jobHandle struct {
+	m      *jobManager
+	ID     string
+	Params map[string]interface{}
+}
+
+func newJobManager(dir string, limits map[string]int) *jobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobManager{
+		dir:    dir,
+		limits: limits,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   map[string]*jobState{},
+		sem:    map[string]chan struct{}{},
+	}
+}
+
+func (m *jobManager) statePath() string {
+	return filepath.Join(m.dir, "jobs.json")
+}
+
+// Start loads the saved jobs and resumes those that had not finished.
+func (m *jobManager) Start() error {
+	var saved []Job
+	if err := readJSONFile(m.statePath(), &saved); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range saved {
+		state := &jobState{job: job}
+		m.jobs[job.ID] = state
+		m.order = append(m.order, job.ID)
+		if job.finished() {
+			continue
+		}
+
+		jt, ok := jobTypes[job.Type]
+		if !ok {
+			m.endLocked(state, JobFailed, newAPIError(ErrCodeInternal, "unknown job type %q", job.Type))
+			continue
+		}
+		m.resetLocked(state)
+		m.logLocked(state, "Requeued after server restart")
+		m.launchLocked(state, jt)
+	}
+	if len(saved) > 0 {
+		logger.Printf("Loaded %d jobs from %s", len(saved), m.statePath())
+	}
+	return m.saveLocked()
+}
+
+// Stop interrupts running jobs, waits for them to return and saves their
+// state so they are resumed on the next Start.
+func (m *jobManager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.saveLocked(); err != nil {
+		logger.Printf("Error saving job state: %v", err)
+	}
+}
+
+// Submit queues a job of the given type.
+func (m *jobManager) Submit(typ string, params map[string]interface{}) (Job, error) {
+	jt, ok := jobTypes[typ]
+	if !ok {
+		return Job{}, newAPIError(ErrCodeBadRequest, "unknown job type %q", typ)
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if jt.Validate != nil {
+		if err := jt.Validate(params); err != nil {
+			return Job{}, err
+		}
+	}
+
+	state := &jobState{job: Job{
+		ID:        newRequestID(),
+		Type:      typ,
+		Status:    JobQueued,
+		Params:    params,
+		Unit:      jt.Unit,
+		Logs:      []JobLog{},
+		CreatedAt: time.Now(),
+	}}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[state.job.ID] = state
+	m.order = append(m.order, state.job.ID)
+	m.logLocked(state, "Queued")
+	m.launchLocked(state, jt)
+	m.pruneLocked()
+	if err := m.saveLocked(); err != nil {
+		logger.Printf("Error saving job state: %v", err)
+	}
+	return m.snapshotLocked(state), nil
+}
+
+// Get returns a copy of a job.
+func (m *jobManager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.jobs[id]
+	if !ok {
+		return Job{}, newAPIError(ErrCodeNotFound, "job %q not found", id)
+	}
+	return m.snapshotLocked(state), nil
+}
+
+// List returns copies of all jobs, newest first.
+func (m *jobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Job, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		list = append(list, m.snapshotLocked(m.jobs[m.order[i]]))
+	}
+	return list
+}
+
+// Cancel stops a queued or running job. Cancelling a finished job has no
+// effect.
+func (m *jobManager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.jobs[id]
+	if !ok {
+		return Job{}, newAPIError(ErrCodeNotFound, "job %q not found", id)
+	}
+	if !state.job.finished() && !state.cancelRequested {
+		state.cancelRequested = true
+		m.logLocked(state, "Cancellation requested")
+		state.cancel()
+	}
+	return m.snapshotLocked(state), nil
+}
+
+// launchLocked starts the goroutine that waits for a free slot and runs the
+// job.
+func (m *jobManager) launchLocked(state *jobState, jt jobType) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	state.cancel = cancel
+
+	sem, ok := m.sem[state.job.Type]
+	if !ok {
+		limit := jt.Concurrency
+		if n, ok := m.limits[state.job.Type]; ok {
+			limit = n
+		}
+		if limit < 1 {
+			limit = 1
+		}
+		sem = make(chan struct{}, limit)
+		m.sem[state.job.Type] = sem
+	}
+
+	handle := &jobHandle{m: m, ID: state.job.ID, Params: state.job.Params}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			m.finish(handle.ID, nil, ctx.Err())
+			return
+		}
+		defer func() { <-sem }()
+
+		m.mu.Lock()
+		now := time.Now()
+		state.job.Status = JobRunning
+		state.job.StartedAt = &now
+		m.logLocked(state, "Started")
+		m.saveLocked()
+		m.mu.Unlock()
+
+		result, err := runJob(ctx, jt, handle)
+		m.finish(handle.ID, result, err)
+	}()
+}
+
+// runJob runs a job, turning a panic into a failure of that job rather than
+// of the server.
+func runJob(ctx context.Context, jt jobType, handle *jobHandle) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			logger.Printf("Job %s panicked: %v\n%s", handle.ID, p, debug.Stack())
+			result, err = nil, newAPIError(ErrCodeInternal, "job failed unexpectedly: %v", p)
+		}
+	}()
+	return jt.Run(ctx, handle)
+}
+
+// finish records the outcome of a job. A job interrupted by Stop is queued
+// again rather than failed.
+func (m *jobManager) finish(id string, result interface{}, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.jobs[id]
+	state.job.Result = result
+
+	switch {
+	case state.cancelRequested:
+		m.endLocked(state, JobCancelled, nil)
+	case m.ctx.Err() != nil:
+		m.resetLocked(state)
+		m.logLocked(state, "Interrupted by server shutdown")
+	case err != nil:
+		m.endLocked(state, JobFailed, err)
+	default:
+		state.job.Progress = 100
+		m.endLocked(state, JobSucceeded, nil)
+	}
+	if err := m.saveLocked(); err != nil {
+		logger.Printf("Error saving job state: %v", err)
+	}
+}
+
+func (m *jobManager) endLocked(state *jobState, status JobStatus, err error) {
+	now := time.Now()
+	state.job.Status = status
+	state.job.FinishedAt = &now
+	state.job.ETASeconds = 0
+	if err != nil {
+		state.job.Error = asAPIError(err)
+		m.logLocked(state, "Failed: "+err.Error())
+	} else {
+		m.logLocked(state, "Finished: "+string(status))
+	}
+	logger.Printf("Job %s (%s) %s", state.job.ID, state.job.Type, status)
+}
+
+// resetLocked returns a job to the queue so that it runs from the start.
+func (m *jobManager) resetLocked(state *jobState) {
+	state.job.Status = JobQueued
+	state.job.Progress = 0
+	state.job.Processed = 0
+	state.job.Throughput = 0
+	state.job.ETASeconds = 0
+	state.job.Result = nil
+	state.job.StartedAt = nil
+}
+
+func (m *jobManager) logLocked(state *jobState, message string) {
+	state.job.Logs = append(state.job.Logs, JobLog{Time: time.Now(), Message: message})
+	if len(state.job.Logs) > maxJobLogs {
+		state.job.Logs = state.job.Logs[len(state.job.Logs)-maxJobLogs:]
+	}
+}
+
+// pruneLocked drops the oldest finished jobs beyond maxStoredJobs.
+func (m *jobManager) pruneLocked() {
+	excess := len(m.order) - maxStoredJobs
+	kept := m.order[:0]
+	for _, id := range m.order {
+		if excess > 0 && m.jobs[id].job.finished() {
+			delete(m.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+func (m *jobManager) snapshotLocked(state *jobState) Job {
+	job := state.job
+	job.Logs = append([]JobLog{}, state.job.Logs...)
+	return job
+}
+
+// saveLocked writes every job to the state file, replacing it atomically.
+func (m *jobManager) saveLocked() error {
+	list := make([]Job, 0, len(m.order))
+	for _, id := range m.order {
+		list = append(list, m.jobs[id].job)
+	}
+	m.lastSave = time.Now()
+	return writeJSONFile(m.statePath(), list)
+}
+
+// Logf appends a line to the job's log.
+func (h *jobHandle) Logf(format string, args ...interface{}) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	if state, ok := h.m.jobs[h.ID]; ok {
+		h.m.logLocked(state, fmt.Sprintf(format, args...))
+	}
+}
+
+// Progress records the number of items processed and, when known, the
+// fraction of the job completed. A fraction of zero or less leaves the
+// percentage and ETA unchanged.
+func (h *jobHandle) Progress(processed int64, fraction float64) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	state, ok := h.m.jobs[h.ID]
+	if !ok || state.job.StartedAt == nil {
+		return
+	}
+
+	elapsed := time.Since(*state.job.StartedAt).Seconds()
+	state.job.Processed = processed
+	if elapsed > 0 {
+		state.job.Throughput = float64(processed) / elapsed
+	}
+	if fraction > 0 {
+		if fraction > 1 {
+			fraction = 1
+		}
+		// 100% is reserved for jobs that have finished
+		state.job.Progress = min(fraction*100, 99.9)
+		state.job.ETASeconds = elapsed * (1 - fraction) / fraction
+	}
+
+	// Progress is frequent; persist it at most once a second
+	if time.Since(h.m.lastSave) > time.Second {
+		if err := h.m.saveLocked(); err != nil {
+			logger.Printf("Error saving job state: %v", err)
+		}
+	}
+}
+
+// encodeJobParams converts a params struct to the generic form stored with a
+// job.
+func encodeJobParams(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]interface{}{}
+	return params, json.Unmarshal(raw, &params)
+}
+
+// decodeJobParams decodes job params into dst, rejecting unknown fields.
+func decodeJobParams(params map[string]interface{}, dst interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return &APIError{Code: ErrCodeBadRequest, Message: "invalid job params", Details: err.Error()}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		return &APIError{Code: ErrCodeBadRequest, Message: "invalid job params", Details: err.Error()}
+	}
+	return nil
+}
+
+// submitJob queues a job described by a JobRequest.
+func submitJob(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling job submission from %s", r.RemoteAddr)
+
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	job, err := jobs.Submit(req.Type, req.Params)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJob(w, http.StatusAccepted, job)
+}
+
+// listJobs lists jobs, optionally filtered by ?type= and ?status=.
+func listJobs(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling jobs request from %s", r.RemoteAddr)
+
+	typ, status := r.URL.Query().Get("type"), r.URL.Query().Get("status")
+	list := []Job{}
+	for _, job := range jobs.List() {
+		if (typ == "" || job.Type == typ) && (status == "" || string(job.Status) == status) {
+			list = append(list, job)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func getJob(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	job, err := jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+func cancelJob(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling job cancellation from %s", r.RemoteAddr)
+
+	job, err := jobs.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+func writeJob(w http.ResponseWriter, status int, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(job)
+}
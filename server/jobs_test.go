@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addJobType registers a job type for the duration of a test.
+func addJobType(t *testing.T, name string, jt jobType) {
+	t.Helper()
+	jobTypes[name] = jt
+	t.Cleanup(func() { delete(jobTypes, name) })
+}
+
+// waitForJob polls a job until it reaches status.
+func waitForJob(t *testing.T, m *jobManager, id string, status JobStatus) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// blockingJob runs until its context is cancelled, reporting on started
+// each time it begins.
+func blockingJob(started chan<- string) jobType {
+	return jobType{Unit: "items", Concurrency: 1, Run: func(ctx context.Context, job *jobHandle) (interface{}, error) {
+		started <- job.ID
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+}
+
+func TestJobPanicFailsOnlyThatJob(t *testing.T) {
+	addJobType(t, "test-panic", jobType{Unit: "items", Run: func(ctx context.Context, job *jobHandle) (interface{}, error) {
+		var params map[string]int
+		params["boom"]++ // nil map write
+		return nil, nil
+	}})
+	addJobType(t, "test-ok", jobType{Unit: "items", Run: func(ctx context.Context, job *jobHandle) (interface{}, error) {
+		return "done", nil
+	}})
+	m := newJobManager(t.TempDir(), nil)
+	t.Cleanup(m.Stop)
+
+	panicked, err := m.Submit("test-panic", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := waitForJob(t, m, panicked.ID, JobFailed)
+	if job.Error == nil || job.Error.Code != ErrCodeInternal || !strings.Contains(job.Error.Message, "unexpectedly") {
+		t.Errorf("error = %+v", job.Error)
+	}
+
+	ok, err := m.Submit("test-ok", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, m, ok.ID, JobSucceeded); job.Result != "done" {
+		t.Errorf("result = %v", job.Result)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	started := make(chan string, 2)
+	addJobType(t, "test-block", blockingJob(started))
+	m := newJobManager(t.TempDir(), nil)
+	t.Cleanup(m.Stop)
+
+	running, _ := m.Submit("test-block", nil)
+	<-started
+	// Concurrency is 1, so the second job waits in the queue
+	queued, _ := m.Submit("test-block", nil)
+	waitForJob(t, m, queued.ID, JobQueued)
+
+	for _, id := range []string{queued.ID, running.ID} {
+		if _, err := m.Cancel(id); err != nil {
+			t.Fatal(err)
+		}
+		job := waitForJob(t, m, id, JobCancelled)
+		if job.FinishedAt == nil || job.Error != nil {
+			t.Errorf("cancelled job = %+v", job)
+		}
+	}
+	if _, err := m.Cancel("missing"); asAPIError(err).Code != ErrCodeNotFound {
+		t.Errorf("cancel of unknown job: %v", err)
+	}
+}
+
+func TestJobsPersistAndResume(t *testing.T) {
+	dir := t.TempDir()
+	started := make(chan string, 2)
+	addJobType(t, "test-block", blockingJob(started))
+
+	m := newJobManager(dir, nil)
+	job, err := m.Submit("test-block", map[string]interface{}{"index": "idx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// Stopping the server interrupts the job and saves it as queued
+	m.Stop()
+
+	var saved []Job
+	if err := readJSONFile(m.statePath(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].ID != job.ID || saved[0].Status != JobQueued || saved[0].Params["index"] != "idx" {
+		t.Fatalf("jobs.json = %+v", saved)
+	}
+	if logs := saved[0].Logs; logs[len(logs)-1].Message != "Interrupted by server shutdown" {
+		t.Errorf("last log = %q", logs[len(logs)-1].Message)
+	}
+
+	// The next server run picks it up again
+	addJobType(t, "test-block", jobType{Unit: "items", Run: func(ctx context.Context, job *jobHandle) (interface{}, error) {
+		return job.Params["index"], nil
+	}})
+	restarted := newJobManager(dir, nil)
+	t.Cleanup(restarted.Stop)
+	if err := restarted.Start(); err != nil {
+		t.Fatal(err)
+	}
+	resumed := waitForJob(t, restarted, job.ID, JobSucceeded)
+	if resumed.Result != "idx" || resumed.Progress != 100 {
+		t.Errorf("resumed job = %+v", resumed)
+	}
+	var requeued bool
+	for _, log := range resumed.Logs {
+		requeued = requeued || log.Message == "Requeued after server restart"
+	}
+	if !requeued {
+		t.Errorf("logs = %+v", resumed.Logs)
+	}
+}
+
+func TestJobsStartFailsUnknownTypes(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeJSONFile(dir+"/jobs.json", []Job{{ID: "j1", Type: "retired", Status: JobRunning, Logs: []JobLog{}}}); err != nil {
+		t.Fatal(err)
+	}
+	m := newJobManager(dir, nil)
+	t.Cleanup(m.Stop)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if job, _ := m.Get("j1"); job.Status != JobFailed {
+		t.Errorf("job = %+v", job)
+	}
+	if _, err := os.Stat(m.statePath()); err != nil {
+		t.Error(err)
+	}
+}
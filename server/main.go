@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os/exec"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 	"os"
 	"strings"
@@ -29,7 +35,21 @@ var (
 
 func init() {
 	// Initialize logger with timestamp and caller info
-	logger = log.New(os.Stdout, "[AVS Console (API)] ", log.Ldate|log.Ltime|log.Lshortfile)
+	logger = log.New(io.MultiWriter(os.Stdout, recentLogs), "[AVS Console (API)] ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	serverConfig = loadServerConfig()
+	asvec = newBackend(serverConfig.MaxBackendCalls, serverConfig.MaxBatchCalls)
+	jobs = newJobManager(serverConfig.DataDir, serverConfig.JobConcurrency)
+	benchmarks = newBenchmarkStore(serverConfig.DataDir)
+	queries = newQueryStore(serverConfig.DataDir, serverConfig.QueryHistorySize)
+	alerts = newAlertManager(serverConfig.DataDir, serverConfig.AlertInterval)
+	upgrades = newUpgradeTracker(serverConfig.DataDir)
+
+	var err error
+	if embedder, err = newEmbedder(serverConfig.Embedder); err != nil {
+		logger.Printf("Text queries disabled: %v", err)
+	}
+	embeddings = newEmbeddingCache(serverConfig.Embedder.CacheSize)
 }
 
 // Node represents an Aerospike node in the cluster
@@ -65,12 +85,61 @@ type Role struct {
 
 // ClusterInfo represents information about the cluster
 type ClusterInfo struct {
-	ClusterID     string   `json:"clusterId"`
+	ClusterID     string   `json:"clusterId,omitempty"`
 	Version       string   `json:"version"`
 	ClusterSize   int      `json:"clusterSize"`
 	TotalVectors  int      `json:"totalVectors"`
 	NodeRoles     []string `json:"nodeRoles"`
-	ActiveCluster string   `json:"activeCluster"`
+	ActiveCluster string   `json:"activeCluster,omitempty"`
+}
+
+// UserList is the response of the users endpoint
+type UserList struct {
+	Available bool   `json:"available"`
+	Data      []User `json:"data"`
+}
+
+// RoleList is the response of the roles endpoint
+type RoleList struct {
+	Available bool   `json:"available"`
+	Data      []Role `json:"data"`
+}
+
+// HealthStatus is the response of the health check endpoint
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// QueryRequest is the body of a vector search request
+type QueryRequest struct {
+	Index string    `json:"index"`
+	Query []float64 `json:"query,omitempty"`
+	Limit int       `json:"limit"`
+	// Ef overrides the index's HNSW ef for this search
+	Ef int `json:"ef,omitempty"`
+	// Key searches with the vector of an existing record instead of Query,
+	// which asvec reads from the index's namespace and Set. Set defaults to
+	// the index's set.
+	Key string `json:"key,omitempty"`
+	Set string `json:"set,omitempty"`
+	// IncludeSeed keeps the record named by Key in the results
+	IncludeSeed bool `json:"includeSeed,omitempty"`
+	// Text is embedded with the configured embedding provider and searched
+	// for instead of Query
+	Text string `json:"text,omitempty"`
+	// Filter keeps results whose metadata bins equal these values. It is
+	// applied to search results, which are fetched in growing batches until
+	// Limit match; fewer are returned when no more than maxFilteredFetch
+	// nearest neighbours contain Limit matches.
+	Filter map[string]interface{} `json:"filter,omitempty"`
+	// IncludeBins limits the metadata returned to these bins
+	IncludeBins []string `json:"includeBins,omitempty"`
+}
+
+// QueryResponse is the result of a vector search
+type QueryResponse struct {
+	Results       []QueryResult `json:"results"`
+	ExecutionTime float64       `json:"executionTime"`
 }
 
 // QueryResult represents a vector search result
@@ -88,6 +157,12 @@ type ConfigInfo struct {
 	CLIInstalled  bool   `json:"cliInstalled"`
 	CLIVersion    string `json:"cliVersion"`
 	CLIDownloadURL string `json:"cliDownloadUrl"`
+	// MinCLIVersion and SupportedVersions describe the asvec versions the
+	// console works with and the AVS versions each of them manages.
+	MinCLIVersion     string       `json:"minCliVersion"`
+	SupportedVersions []CLISupport `json:"supportedVersions"`
+	// CLISupported is false when the installed asvec is outside the matrix.
+	CLISupported bool `json:"cliSupported"`
 }
 
 // IndexInfo represents detailed information about an index
@@ -106,49 +181,33 @@ type IndexInfo struct {
 	Status          string           `json:"status"`
 	Vertices        int              `json:"vertices"`
 	Labels          map[string]string `json:"labels"`
+	// Storage is the namespace the index itself is stored in, or empty
+	// when asvec does not print it.
 	Storage         string           `json:"storage"`
 	Parameters      map[string]string `json:"parameters"`
 }
 
 // Helper function to update node cache
-func updateNodeCache() error {
+func updateNodeCache(ctx context.Context) error {
 	nodeCacheMutex.Lock()
 	defer nodeCacheMutex.Unlock()
 
-	cmd := exec.Command("asvec", "node", "ls", "--format", "1")
-	output, err := cmd.Output()
+	output, err := asvec.Run(ctx, "node-ls", cli.profile.listArgs("node", "ls")...)
 	if err != nil {
 		return err
 	}
 
 	// Parse the output
-	lines := strings.Split(string(output), "\n")
-	if len(lines) < 3 {
+	if strings.Count(string(output), "\n") < 2 {
 		return fmt.Errorf("invalid node list output")
 	}
+	nodes := parseNodeTable(output, cli.profile.nodes)
 
 	// Reset roles slice
 	uniqueRoles := make(map[string]bool)
-	var nodes []Node
-
-	// Process each line (skip first two header lines)
-	for _, line := range lines[2:] {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		fields := strings.SplitN(line, ",", 7)
-		if len(fields) >= 6 {
-			role := strings.TrimSpace(fields[2]) // Roles field
-			if role != "" && role != "N/A" {
-				uniqueRoles[role] = true
-			}
-			nodes = append(nodes, Node{
-				NodeID:   strings.TrimSpace(fields[1]),
-				Role:     role,
-				Endpoint: strings.TrimSpace(fields[3]),
-				Version:  strings.TrimSpace(fields[5]),
-			})
+	for _, node := range nodes {
+		if node.Role != "" && node.Role != "N/A" {
+			uniqueRoles[node.Role] = true
 		}
 	}
 
@@ -170,43 +229,90 @@ func updateNodeCache() error {
 func main() {
 	logger.Println("Starting AVS Server...")
 	
-	// Add a basic health check endpoint
-	http.HandleFunc("/api/health", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		logger.Println("Health check requested")
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-	}))
-
-	// Add debug endpoint to test JSON response
-	http.HandleFunc("/api/debug", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		logger.Println("Debug endpoint requested")
-		w.Header().Set("Content-Type", "application/json")
-		debugInfo := map[string]interface{}{
-			"timestamp": time.Now(),
-			"headers":   r.Header,
-			"method":    r.Method,
-			"url":       r.URL.String(),
-		}
-		json.NewEncoder(w).Encode(debugInfo)
-	}))
+	// Pick the output parsers for the installed asvec, refusing to run with
+	// one too old to manage
+	if err := detectCLI(context.Background()); err != nil {
+		logger.Fatalf("Cannot start: %v", err)
+	}
+	
+	// Routes and the OpenAPI document are both generated from apiRoutes
+	handler := corsMiddleware(withRequestID(newAPIRouter().ServeHTTP))
 
-	http.HandleFunc("/api/cluster/info", corsMiddleware(getClusterInfo))
-	http.HandleFunc("/api/nodes", corsMiddleware(getNodes))
-	http.HandleFunc("/api/indexes", corsMiddleware(getIndexes))
-	http.HandleFunc("/api/users", corsMiddleware(getUsers))
-	http.HandleFunc("/api/roles", corsMiddleware(getRoles))
-	http.HandleFunc("/api/query", corsMiddleware(executeQuery))
-	http.HandleFunc("/api/config", corsMiddleware(getConfig))
+	// Request contexts derive from baseCtx so that cancelling it kills any
+	// asvec subprocesses still running when the drain timeout expires.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
 
-	// Enable CORS
-	http.HandleFunc("/", corsMiddleware(enableCORS))
+	// Resume jobs left unfinished by the previous run
+	if err := jobs.Start(); err != nil {
+		logger.Printf("Error loading saved jobs: %v", err)
+	}
+	if err := alerts.Start(); err != nil {
+		logger.Printf("Error loading alert rules: %v", err)
+	}
 
-	// Start server
 	port := ":8080"
-	logger.Printf("Server listening on %s", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
+	srv := &http.Server{
+		Addr:        port,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+
+	// Start server
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Printf("Server listening on %s", port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serverErr:
 		logger.Fatalf("Server failed to start: %v", err)
+	case <-sigCtx.Done():
+		stop()
+	}
+
+	logger.Printf("Shutting down, draining in-flight requests for up to %s", serverConfig.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout)
+	defer cancelDrain()
+
+	if err := srv.Shutdown(drainCtx); err != nil {
+		logger.Printf("Drain timeout exceeded, cancelling in-flight requests: %v", err)
+		cancelRequests()
+		srv.Close()
+	}
+
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Printf("Server error during shutdown: %v", err)
+	}
+
+	// Running jobs are interrupted and saved so the next start resumes them
+	jobs.Stop()
+	alerts.Stop()
+	queries.Stop()
+	logger.Println("Server stopped")
+}
+
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	logger.Println("Health check requested")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthStatus{Status: "ok"})
+}
+
+// getDebug echoes the request back to help test JSON responses
+func getDebug(w http.ResponseWriter, r *http.Request) {
+	logger.Println("Debug endpoint requested")
+	w.Header().Set("Content-Type", "application/json")
+	debugInfo := map[string]interface{}{
+		"timestamp": time.Now(),
+		"headers":   r.Header,
+		"method":    r.Method,
+		"url":       r.URL.String(),
 	}
+	json.NewEncoder(w).Encode(debugInfo)
 }
 
 func enableCORS(w http.ResponseWriter, r *http.Request) {
@@ -214,6 +320,7 @@ func enableCORS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 	
 	logger.Printf("Setting CORS headers for request from: %s", r.RemoteAddr)
 }
@@ -238,259 +345,243 @@ func getNodes(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w, r)
 	logger.Printf("Handling node list request from %s", r.RemoteAddr)
 
-	// Prepare the command without --format 1 and --verbose for logging
-	cmd := exec.Command("asvec", "node", "ls", "--format", "1")
-	logger.Printf("Executing command: %s", cmd.String()) // Log the command
+	nodes, err := listNodes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
 
-	// Capture output and error
-	output, err := cmd.CombinedOutput() // This captures both stdout and stderr
+// listNodes runs `asvec node ls` and parses its CSV output
+func listNodes(ctx context.Context) ([]Node, error) {
+	output, err := asvec.Run(ctx, "node-ls", cli.profile.listArgs("node", "ls")...)
 	if err != nil {
 		logger.Printf("Error executing node list command: %v", err)
-		logger.Printf("%s", string(output)) // Log the output without prefix
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode([]Node{}) // Return empty array instead of dummy data
-		return
+		logStderr(err)
+		return nil, backendError("list nodes", err)
 	}
 
 	// Log the command output with an extra newline
 	logger.Printf("\n%s", string(output))
 
-	// Parse CSV output
-	lines := strings.Split(string(output), "\n")
-	var nodes []Node
-
-	// Skip header lines (first two lines)
-	for _, line := range lines[2:] {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		fields := strings.Split(line, ",")
-		if len(fields) >= 6 {
-			nodes = append(nodes, Node{
-				NodeID:   strings.TrimSpace(fields[1]), // Node ID
-				Role:     strings.TrimSpace(fields[2]), // Roles
-				Endpoint: strings.TrimSpace(fields[3]), // Endpoint
-				Version:  strings.TrimSpace(fields[5]), // Version
-			})
-		}
-	}
+	// Parse CSV output with the columns of the detected asvec version
+	nodes := parseNodeTable(output, cli.profile.nodes)
 
-	// If no nodes were found, return an empty array
+	// A reachable cluster always reports at least one node
 	if len(nodes) == 0 {
 		logger.Printf("No nodes found in command output")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode([]Node{})
-		return
+		return nil, newAPIError(ErrCodeBackend, "asvec returned no nodes")
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(nodes)
+	return nodes, nil
 }
 
 func getIndexes(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w, r)
 	logger.Printf("Handling index list request from %s", r.RemoteAddr)
 
-	// Prepare the command with --format 1 and --verbose for logging
-	cmd := exec.Command("asvec", "index", "ls", "--format", "1", "--verbose")
-	logger.Printf("Executing command: %s", cmd.String()) // Log the command
-
-	// Capture output and error
-	output, err := cmd.CombinedOutput() // This captures both stdout and stderr
+	indexes, err := listIndexes(r.Context())
 	if err != nil {
-		logger.Printf("Error executing index list command: %v", err)
-		logger.Printf("%s", string(output)) // Log the output without prefix
-		http.Error(w, "Failed to get indexes", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	// Log the command output with an extra newline
-	logger.Printf("\n%s", string(output))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(indexes)
+}
 
-	// Parse CSV output
-	lines := strings.Split(string(output), "\n")
-	var indexes []IndexInfo
+func getIndex(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	name := r.PathValue("name")
+	logger.Printf("Handling index %q request from %s", name, r.RemoteAddr)
 
-	// Skip first two lines (header rows)
-	for _, line := range lines[2:] {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+	index, err := findIndex(r.Context(), name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-		fields := strings.Split(line, ",")
-		if len(fields) >= 14 { // Ensure there are enough fields
-			// Debugging output to check raw field values with their index numbers
-			for i, field := range fields {
-				logger.Printf("Field[%d]: %s", i, field)
-			}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(index)
+}
 
-			// Parse parameters from the last field
-			params := make(map[string]string)
-			paramStr := fields[13]
-			paramLines := strings.Split(paramStr, "\n")
-			for _, param := range paramLines {
-				parts := strings.SplitN(param, "\\,", 2)
-				if len(parts) == 2 {
-					params[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
-				}
-			}
+// findIndex returns the index with the given name, or a not_found error
+func findIndex(ctx context.Context, name string) (IndexInfo, error) {
+	indexes, err := listIndexes(ctx)
+	if err != nil {
+		return IndexInfo{}, err
+	}
+	for _, index := range indexes {
+		if index.Name == name {
+			return index, nil
+		}
+	}
+	return IndexInfo{}, newAPIError(ErrCodeNotFound, "index %q not found", name)
+}
 
-			// Parse labels
-			labels := make(map[string]string)
-			if fields[11] != "map[]" {
-				labelStr := strings.Trim(fields[11], "map[]")
-				labelPairs := strings.Split(labelStr, ",")
-				for _, pair := range labelPairs {
-					kv := strings.Split(pair, ":")
-					if len(kv) == 2 {
-						labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
-					}
-				}
-			}
+// listIndexes runs `asvec index ls` and parses its verbose CSV output
+func listIndexes(ctx context.Context) ([]IndexInfo, error) {
+	output, err := asvec.Run(ctx, "index-ls", cli.profile.listArgs("index", "ls", "--verbose")...)
+	if err != nil {
+		logger.Printf("Error executing index list command: %v", err)
+		logStderr(err)
+		return nil, backendError("list indexes", err)
+	}
 
-			dimensions, _ := strconv.Atoi(fields[5])
-			unmerged, _ := strconv.Atoi(fields[7])
-			vectorRecords, _ := strconv.Atoi(fields[8])
-			vertices, _ := strconv.Atoi(fields[12]) // Adjusted index for vertices
-
-			// Log the parsed values with their index numbers
-			logger.Printf("Parsed Index: Name=%s, Mode=%s, Status=%s, Unmerged=%d, UnmergedPercent=%s, Size=%s, VectorRecords=%d",
-				fields[1], fields[10], fields[11], unmerged, fields[9], fields[8], vectorRecords)
-
-			indexes = append(indexes, IndexInfo{
-				Name:            fields[1],
-				Namespace:       fields[2],
-				Set:             fields[3],
-				Field:           fields[4],
-				Dimensions:      dimensions,
-				DistanceMetric:  fields[6],
-				Unmerged:        unmerged,
-				VectorRecords:   vectorRecords,
-				Size:            fields[9], // Ensure this is the correct field for size
-				UnmergedPercent: fields[10], // Ensure this is the correct field for unmerged percent
-				Mode:            fields[11], // Ensure this is the correct field for mode
-				Status:          fields[12], // Ensure this is the correct field for status
-				Vertices:        vertices,
-				Labels:          labels,
-				Storage:         fields[2],
-				Parameters:      params,
-			})
-		}
+	// Log the command output with an extra newline
+	logger.Printf("\n%s", string(output))
+
+	// Parse CSV output with the columns of the detected asvec version
+	indexes := parseIndexTable(output, cli.profile.indexes)
+	for _, index := range indexes {
+		logger.Printf("Parsed Index: Name=%s, Mode=%s, Status=%s, Unmerged=%d, UnmergedPercent=%s, Size=%s, VectorRecords=%d",
+			index.Name, index.Mode, index.Status, index.Unmerged, index.UnmergedPercent, index.Size, index.VectorRecords)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(indexes)
+	return indexes, nil
 }
 
 func getUsers(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w, r)
 	logger.Printf("Handling users request from %s", r.RemoteAddr)
 	
-	// Always set content type header first
-	w.Header().Set("Content-Type", "application/json")
-	
-	cmd := exec.Command("asvec", "user", "ls")
-	_, err := cmd.Output()
-	
+	users, err := listUsers(r.Context())
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			stderr := string(exitErr.Stderr)
-			logger.Printf("Command stderr: %s", stderr)
-			
-			if strings.Contains(stderr, "Unimplemented") {
-				logger.Println("Users feature is unimplemented")
-				if err := json.NewEncoder(w).Encode(map[string]interface{}{
-					"error": "unimplemented",
-					"available": false,
-				}); err != nil {
-					logger.Printf("Error encoding response: %v", err)
-				}
-				return
-			}
-		}
-		logger.Printf("Error executing user command: %v", err)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "failed to fetch users",
-			"available": false,
-		}); err != nil {
-			logger.Printf("Error encoding response: %v", err)
-		}
+		writeError(w, r, err)
 		return
 	}
 	
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"available": true,
-		"data": []interface{}{},
-	}); err != nil {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(UserList{Available: true, Data: users}); err != nil {
 		logger.Printf("Error encoding response: %v", err)
 	}
 }
 
-func getRoles(w http.ResponseWriter, r *http.Request) {
+func getUser(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w, r)
-	logger.Printf("Handling roles request from %s", r.RemoteAddr)
-	
-	// Always set content type header first
-	w.Header().Set("Content-Type", "application/json")
-	
-	cmd := exec.Command("asvec", "role", "ls")
-	_, err := cmd.Output()
-	
+	username := r.PathValue("username")
+	logger.Printf("Handling user %q request from %s", username, r.RemoteAddr)
+
+	users, err := listUsers(r.Context())
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			stderr := string(exitErr.Stderr)
-			logger.Printf("Command stderr: %s", stderr)
-			
-			if strings.Contains(stderr, "Unimplemented") {
-				logger.Println("Roles feature is unimplemented")
-				if err := json.NewEncoder(w).Encode(map[string]interface{}{
-					"error": "unimplemented",
-					"available": false,
-				}); err != nil {
-					logger.Printf("Error encoding response: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	for _, user := range users {
+		if user.Username == username {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(user)
+			return
+		}
+	}
+	writeError(w, r, newAPIError(ErrCodeNotFound, "user %q not found", username))
+}
+
+// listUsers runs `asvec user ls` and parses its CSV output
+func listUsers(ctx context.Context) ([]User, error) {
+	output, err := asvec.Run(ctx, "user-ls", cli.profile.listArgs("user", "ls")...)
+	if err != nil {
+		logger.Printf("Error executing user command: %v", err)
+		logStderr(err)
+		return nil, backendError("list users", err)
+	}
+
+	users := []User{}
+	lines := strings.Split(string(output), "\n")
+	// Skip header lines (first two lines)
+	for i, line := range lines {
+		if i < 2 || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ",", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		user := User{Username: strings.TrimSpace(fields[1]), Roles: []string{}}
+		if len(fields) == 3 {
+			for _, role := range strings.Split(strings.Trim(fields[2], "\" "), ",") {
+				if role = strings.TrimSpace(role); role != "" {
+					user.Roles = append(user.Roles, role)
 				}
-				return
 			}
 		}
-		logger.Printf("Error executing role command: %v", err)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "failed to fetch roles",
-			"available": false,
-		}); err != nil {
-			logger.Printf("Error encoding response: %v", err)
-		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func getRoles(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling roles request from %s", r.RemoteAddr)
+	
+	roles, err := listRoles(r.Context())
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"available": true,
-		"data": []interface{}{},
-	}); err != nil {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(RoleList{Available: true, Data: roles}); err != nil {
 		logger.Printf("Error encoding response: %v", err)
 	}
 }
 
+func getRole(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	name := r.PathValue("name")
+	logger.Printf("Handling role %q request from %s", name, r.RemoteAddr)
+
+	roles, err := listRoles(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(role)
+			return
+		}
+	}
+	writeError(w, r, newAPIError(ErrCodeNotFound, "role %q not found", name))
+}
+
+// listRoles runs `asvec role ls` and parses its CSV output
+func listRoles(ctx context.Context) ([]Role, error) {
+	output, err := asvec.Run(ctx, "role-ls", cli.profile.listArgs("role", "ls")...)
+	if err != nil {
+		logger.Printf("Error executing role command: %v", err)
+		logStderr(err)
+		return nil, backendError("list roles", err)
+	}
+
+	roles := []Role{}
+	lines := strings.Split(string(output), "\n")
+	// Skip header lines (first two lines)
+	for i, line := range lines {
+		if i < 2 || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			continue
+		}
+		roles = append(roles, Role{Name: strings.TrimSpace(fields[1])})
+	}
+	return roles, nil
+}
+
 func getClusterInfo(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w, r)
 	logger.Printf("Handling cluster info request from %s", r.RemoteAddr)
 
-	// Prepare the command without --format 1 and --verbose for logging
-	cmd := exec.Command("asvec", "node", "ls")
-	logger.Printf("Executing command: %s", cmd.String()) // Log the command
-
-	// Capture output and error
-	output, err := cmd.CombinedOutput() // This captures both stdout and stderr
+	output, err := asvec.Run(r.Context(), "node-ls", cli.profile.listArgs("node", "ls")...)
 	if err != nil {
 		logger.Printf("Error executing node list command: %v", err)
-		logger.Printf("%s", string(output)) // Log the output
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "Failed to get cluster info",
-			"clusterSize": 0,
-			"nodeRoles": []string{},
-			"version": "Unknown",
-			"totalVectors": 0,
-		})
+		logStderr(err)
+		writeError(w, r, backendError("get cluster info", err))
 		return
 	}
 
@@ -498,32 +589,15 @@ func getClusterInfo(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("%s", string(output))
 
 	// Parse node list output
-	lines := strings.Split(string(output), "\n")
-	var nodes []Node
+	nodes := parseNodeTable(output, cli.profile.nodes)
 	var versions = make(map[string]bool)
-	var roles []string
-
-	// Skip header lines (first two lines)
-	for _, line := range lines[2:] {
-		if strings.TrimSpace(line) == "" {
-			continue
+	roles := []string{}
+	for _, node := range nodes {
+		if node.Version != "" {
+			versions[node.Version] = true
 		}
-		fields := strings.Split(line, ",")
-		if len(fields) >= 6 {
-			version := strings.TrimSpace(fields[5])
-			if version != "" {
-				versions[version] = true
-			}
-			role := strings.TrimSpace(fields[2])
-			if role != "" {
-				roles = append(roles, role)
-			}
-			nodes = append(nodes, Node{
-				NodeID:   strings.TrimSpace(fields[1]),
-				Role:     role,
-				Endpoint: strings.TrimSpace(fields[3]),
-				Version:  version,
-			})
+		if node.Role != "" {
+			roles = append(roles, node.Role)
 		}
 	}
 
@@ -542,10 +616,7 @@ func getClusterInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Get total vectors (if available)
 	totalVectors := 0
-	cmd = exec.Command("asvec", "cluster", "info")
-	logger.Printf("Executing command: %s", cmd.String()) // Log the command
-
-	output, err = cmd.CombinedOutput() // Capture output and error
+	output, err = asvec.Run(r.Context(), "cluster-info", "cluster", "info")
 	if err == nil {
 		var clusterInfo struct {
 			TotalVectors int `json:"totalVectors"`
@@ -557,11 +628,11 @@ func getClusterInfo(w http.ResponseWriter, r *http.Request) {
 
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"clusterSize": len(nodes),
-		"nodeRoles": roles,
-		"version": version,
-		"totalVectors": totalVectors,
+	json.NewEncoder(w).Encode(ClusterInfo{
+		ClusterSize:  len(nodes),
+		NodeRoles:    roles,
+		Version:      version,
+		TotalVectors: totalVectors,
 	})
 }
 
@@ -569,65 +640,220 @@ func executeQuery(w http.ResponseWriter, r *http.Request) {
 	enableCORS(w, r)
 	logger.Printf("Handling query request from %s", r.RemoteAddr)
 	
-	if r.Method != "POST" {
-		logger.Printf("Invalid method %s for query endpoint", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	
 	// Parse the query parameters from request body
-	var queryParams struct {
-		Index string    `json:"index"`
-		Query []float64 `json:"query"`
-		Limit int       `json:"limit"`
-	}
+	var queryParams QueryRequest
 	
 	if err := json.NewDecoder(r.Body).Decode(&queryParams); err != nil {
 		logger.Printf("Failed to parse query parameters: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	
+	results, elapsed, err := runQuery(r.Context(), runShared, queryParams)
+	recordQuery(r, queryParams, results, elapsed, err, "")
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	
+	logger.Printf("Query returned %d results", len(results))
+
+	response := QueryResponse{
+		Results:       results,
+		ExecutionTime: elapsed.Seconds(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runQuery runs a QueryRequest: it searches by vector, text or record key,
+// and applies the seed exclusion, filter and bin selection to the
+// results.
+func runQuery(ctx context.Context, run searchRunner, queryParams QueryRequest) ([]QueryResult, time.Duration, error) {
+	if queryParams.Limit < 1 {
+		queryParams.Limit = 10
+	}
+	limit := queryParams.Limit
+	
+	inputs := 0
+	for _, given := range []bool{len(queryParams.Query) > 0, queryParams.Key != "", queryParams.Text != ""} {
+		if given {
+			inputs++
+		}
+	}
+	if inputs > 1 {
+		return nil, 0, newAPIError(ErrCodeBadRequest, "give only one of a query vector, a record key or text")
+	}
+	
+	if queryParams.Text != "" {
+		index, err := findIndex(ctx, queryParams.Index)
+		if err != nil {
+			return nil, 0, err
+		}
+		vector, err := embedText(ctx, index, queryParams.Text)
+		if err != nil {
+			return nil, 0, err
+		}
+		queryParams.Query = vector
+	}
+	
+	if queryParams.Key != "" {
+		if queryParams.Set == "" {
+			index, err := findIndex(ctx, queryParams.Index)
+			if err != nil {
+				return nil, 0, err
+			}
+			queryParams.Set = index.Set
+		}
+		if !queryParams.IncludeSeed {
+			// Ask for one more so the limit still holds once the seed is dropped
+			limit++
+		}
+	}
+	search := searchQuery{Vector: queryParams.Query, Key: queryParams.Key, Set: queryParams.Set}
+	
 	logger.Printf("Executing query on index '%s' with limit %d", 
 		queryParams.Index, queryParams.Limit)
 	
-	// Execute asvec query command
-	queryCmd := fmt.Sprintf("asvec query -i %s -k %d", queryParams.Index, queryParams.Limit)
-	logger.Printf("Executing: %s", queryCmd)
-	cmd := exec.Command("sh", "-c", queryCmd)
-	output, err := cmd.Output()
+	// The filter applies to search results, so a filtered search asks for
+	// more until enough results match, the index has no more to give, or
+	// maxFilteredFetch is reached.
+	var (
+		results []QueryResult
+		elapsed time.Duration
+	)
+	for {
+		found, took, err := runSearch(ctx, run, queryParams.Index, search, limit, queryParams.Ef)
+		elapsed += took
+		if err != nil {
+			return nil, elapsed, err
+		}
+		exhausted := len(found) < limit
+		if queryParams.Key != "" && !queryParams.IncludeSeed {
+			found = dropResult(found, queryParams.Key)
+		}
+		results = filterResults(found, queryParams.Filter, queryParams.IncludeBins)
+		if len(queryParams.Filter) == 0 || len(results) >= queryParams.Limit || exhausted || limit >= maxFilteredFetch {
+			break
+		}
+		limit = min(limit*4, maxFilteredFetch)
+	}
+	if len(results) > queryParams.Limit {
+		results = results[:queryParams.Limit]
+	}
+	return results, elapsed, nil
+}
+
+// dropResult removes the result for key, if any.
+func dropResult(results []QueryResult, key string) []QueryResult {
+	kept := make([]QueryResult, 0, len(results))
+	for _, r := range results {
+		if r.ID != key {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// maxFilteredFetch bounds how many results a filtered search asks for
+// while looking for Limit matches.
+const maxFilteredFetch = 1000
+
+// searchQuery is what a search looks for: Vector, or when Key is set the
+// vector of that record in Set, which asvec reads from the index's
+// namespace itself.
+type searchQuery struct {
+	Vector []float64
+	Key    string
+	Set    string
+}
+
+// searchRunner runs an `asvec query` and returns its output with the time
+// the search took.
+type searchRunner func(ctx context.Context, op string, args ...string) ([]byte, time.Duration, error)
+
+// runShared is the searchRunner of interactive searches. Identical
+// concurrent searches share one call on the backend's shared slots, and the
+// time is the wall-clock time of the call.
+func runShared(ctx context.Context, op string, args ...string) ([]byte, time.Duration, error) {
+	start := time.Now()
+	output, err := asvec.Run(ctx, op, args...)
+	return output, time.Since(start), err
+}
+
+// runSearch runs an ANN search through `asvec query` with run and returns
+// the results with the time the search took. An ef of zero uses the index's
+// configured value.
+func runSearch(ctx context.Context, run searchRunner, index string, query searchQuery, limit, ef int) ([]QueryResult, time.Duration, error) {
+	args := []string{"query", "-i", index, "-k", strconv.Itoa(limit)}
+	switch {
+	case query.Key != "":
+		args = append(args, "--key", query.Key)
+		if query.Set != "" {
+			args = append(args, "--set", query.Set)
+		}
+	case len(query.Vector) > 0:
+		encoded, _ := json.Marshal(query.Vector)
+		args = append(args, "--vector", string(encoded))
+	}
+	if ef > 0 {
+		args = append(args, "--hnsw-ef", strconv.Itoa(ef))
+	}
+
+	output, elapsed, err := run(ctx, "query", args...)
 	if err != nil {
 		logger.Printf("Error executing query command: %v", err)
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			logger.Printf("Command stderr: %s", string(exitErr.Stderr))
-		}
-		http.Error(w, "Query execution failed", http.StatusInternalServerError)
-		return
+		logStderr(err)
+		return nil, elapsed, backendError("execute query", err)
 	}
-	
-	logger.Printf("Raw query output: %s", string(output))
-	
-	// Parse the JSON output from asvec
-	var results []QueryResult
+
+	results := []QueryResult{}
 	if err := json.Unmarshal(output, &results); err != nil {
 		logger.Printf("Failed to parse query results: %v", err)
-		http.Error(w, "Failed to parse query results", http.StatusInternalServerError)
-		return
+		return nil, elapsed, &APIError{Code: ErrCodeBackend, Message: "failed to parse query results", Details: err.Error()}
 	}
-	
-	logger.Printf("Query returned %d results", len(results))
+	return results, elapsed, nil
+}
 
-	// Fix the response struct definition
-	response := struct {
-		Results       []QueryResult `json:"results"`
-		ExecutionTime float64       `json:"executionTime"`
-	}{
-		Results:       results,
-		ExecutionTime: 0.023,
+// asvecConfigPath is the asvec config file the console reports and
+// includes in diagnostics.
+const asvecConfigPath = "/etc/aerospike/asvec.yml"
+
+// asvecConfig is the default section of the asvec config file.
+type asvecConfig struct {
+	Host                string `yaml:"host"`
+	Seeds               string `yaml:"seeds"`
+	ListenerName        string `yaml:"listener-name"`
+	Credentials         string `yaml:"credentials"`
+	TLSCAFile           string `yaml:"tls-cafile"`
+	TLSCertFile         string `yaml:"tls-certfile"`
+	TLSKeyFile          string `yaml:"tls-keyfile"`
+	TLSHostnameOverride string `yaml:"tls-hostname-override"`
+}
+
+// readASVECConfig returns the default section of the asvec config file, or
+// an empty one when the file cannot be read.
+func readASVECConfig() asvecConfig {
+	content, err := os.ReadFile(asvecConfigPath)
+	if err != nil {
+		logger.Printf("Failed to read config file: %v", err)
+		return asvecConfig{}
 	}
+	return parseASVECConfig(content)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// parseASVECConfig reads the default section of an asvec config file.
+// Commented-out settings are left empty.
+func parseASVECConfig(content []byte) asvecConfig {
+	var file struct {
+		Default asvecConfig `yaml:"default"`
+	}
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		logger.Printf("Failed to parse YAML: %v", err)
+		return asvecConfig{}
+	}
+	return file.Default
 }
 
 func getConfig(w http.ResponseWriter, r *http.Request) {
@@ -640,51 +866,25 @@ func getConfig(w http.ResponseWriter, r *http.Request) {
 	_, err := exec.LookPath("asvec")
 	asvecInstalled := err == nil
 	
-	// Try to read the config file
-	configPath := "/etc/aerospike/asvec.yml"
-	host := ""
-	seeds := ""
-	
-	if content, err := os.ReadFile(configPath); err == nil {
-		// Parse YAML to extract host and seeds
-		var yamlConfig map[string]interface{}
-		if err := yaml.Unmarshal(content, &yamlConfig); err == nil {
-			logger.Printf("Parsed YAML config: %+v", yamlConfig)
-			
-			if defaultConfig, ok := yamlConfig["default"].(map[string]interface{}); ok {
-				// Check if host is not commented out
-				if h, ok := defaultConfig["host"].(string); ok && h != "" {
-					host = h
-					logger.Printf("Found host configuration: %s", host)
-				}
-				
-				// Check if seeds is not commented out
-				if s, ok := defaultConfig["seeds"].(string); ok && s != "" {
-					seeds = s
-					logger.Printf("Found seeds configuration: %s", seeds)
-				}
-			}
-		} else {
-			logger.Printf("Failed to parse YAML: %v", err)
-		}
-	} else {
-		logger.Printf("Failed to read config file: %v", err)
-	}
+	configPath := asvecConfigPath
+	asvecSettings := readASVECConfig()
 	
 	configInfo := ConfigInfo{
 		ConfigFile:    configPath,
-		Host:         host,
-		Seeds:        seeds,
+		Host:         asvecSettings.Host,
+		Seeds:        asvecSettings.Seeds,
 		CLIInstalled: asvecInstalled,
 		CLIVersion:   "",
 		CLIDownloadURL: "https://github.com/aerospike/asvec",
+		MinCLIVersion: minCLIVersion(),
+		SupportedVersions: supportedCLIVersions(),
 	}
 	
 	// If asvec is installed, get its version
 	if asvecInstalled {
-		cmd := exec.Command("asvec", "--version")
-		if output, err := cmd.Output(); err == nil {
+		if output, err := asvec.Run(r.Context(), "version", "--version"); err == nil {
 			configInfo.CLIVersion = strings.TrimSpace(string(output))
+			configInfo.CLISupported = cliProfileFor(configInfo.CLIVersion) != nil
 		}
 	}
 	
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets when notifier settings are read back.
+const redacted = "********"
+
+// NotifierConfig configures where alert notifications are sent. Type is
+// "webhook" (the notification as JSON), "slack" (a Slack-compatible incoming
+// webhook) or "smtp".
+type NotifierConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// URL and Headers are used by the webhook and slack types. Both are
+	// read back redacted, like Password.
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// The smtp type sends mail through Host ("host:port"), authenticating
+	// with Username and Password when a username is set.
+	Host     string   `json:"host,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// AlertNotification is sent when an alert starts firing, while it keeps
+// firing past its repeat interval, and when it resolves.
+type AlertNotification struct {
+	// Status is "firing" or "resolved".
+	Status    string     `json:"status"`
+	Rule      string     `json:"rule"`
+	Metric    string     `json:"metric"`
+	Subject   string     `json:"subject"`
+	Severity  string     `json:"severity"`
+	Value     float64    `json:"value"`
+	Operator  string     `json:"operator"`
+	Threshold float64    `json:"threshold"`
+	StartsAt  time.Time  `json:"startsAt"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"`
+	Message   string     `json:"message"`
+}
+
+// notifier delivers alert notifications.
+type notifier interface {
+	Notify(ctx context.Context, notification AlertNotification) error
+}
+
+// newNotifier builds the notifier described by cfg.
+func newNotifier(cfg NotifierConfig) (notifier, error) {
+	switch cfg.Type {
+	case "webhook", "slack":
+		if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+			return nil, fmt.Errorf("notifier %s needs an http or https url", cfg.Name)
+		}
+		return &webhookNotifier{url: cfg.URL, headers: cfg.Headers, slack: cfg.Type == "slack", client: &http.Client{}}, nil
+	case "smtp":
+		if cfg.Host == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, fmt.Errorf("notifier %s needs a host, from and to", cfg.Name)
+		}
+		if _, _, err := net.SplitHostPort(cfg.Host); err != nil {
+			return nil, fmt.Errorf("notifier %s: host must be host:port: %w", cfg.Name, err)
+		}
+		return &smtpNotifier{cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("notifier %s has unknown type %q (want webhook, slack or smtp)", cfg.Name, cfg.Type)
+}
+
+// webhookNotifier posts the notification as JSON or, for Slack, as a
+// message with a text field.
+type webhookNotifier struct {
+	url     string
+	headers map[string]string
+	slack   bool
+	client  *http.Client
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	ctx, cancel := context.WithTimeout(ctx, serverConfig.timeoutFor("notify"))
+	defer cancel()
+
+	var payload interface{} = notification
+	if n.slack {
+		payload = map[string]string{"text": notificationText(notification)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range n.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// smtpNotifier mails the notification as plain text, upgrading to TLS when
+// the server offers STARTTLS.
+type smtpNotifier struct {
+	cfg NotifierConfig
+	// rootCAs verifies the server's certificate; nil uses the system roots.
+	rootCAs *x509.CertPool
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	ctx, cancel := context.WithTimeout(ctx, serverConfig.timeoutFor("notify"))
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", n.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(n.cfg.Host)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		// As in net/smtp.SendMail, the certificate must match the host
+		if err := client.StartTLS(&tls.Config{ServerName: host, RootCAs: n.rootCAs}); err != nil {
+			return err
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("[%s] %s: %s", strings.ToUpper(notification.Status), notification.Rule, notification.Subject)
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		n.cfg.From, strings.Join(n.cfg.To, ", "), subject, time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(notificationText(notification), "\n", "\r\n"))
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// notificationText renders a notification for humans.
+func notificationText(n AlertNotification) string {
+	status := "FIRING"
+	if n.Status == "resolved" {
+		status = "RESOLVED"
+	}
+	return fmt.Sprintf("[%s] %s (%s) on %s\n%s\nSince %s",
+		status, n.Rule, n.Severity, n.Subject, n.Message, n.StartsAt.Format(time.RFC3339))
+}
+
+// redactNotifier hides secrets in a notifier's settings. Webhook and Slack
+// URLs are secrets too, since they carry the token that lets anyone post.
+func redactNotifier(cfg NotifierConfig) NotifierConfig {
+	if cfg.URL != "" {
+		cfg.URL = redacted
+	}
+	if cfg.Password != "" {
+		cfg.Password = redacted
+	}
+	if len(cfg.Headers) > 0 {
+		headers := make(map[string]string, len(cfg.Headers))
+		for name := range cfg.Headers {
+			headers[name] = redacted
+		}
+		cfg.Headers = headers
+	}
+	return cfg
+}
+
+// restoreSecrets puts back secrets that a client sent in their redacted
+// form, so that settings read with GET can be sent back with PUT.
+func restoreSecrets(cfg NotifierConfig, previous []NotifierConfig) (NotifierConfig, error) {
+	var old *NotifierConfig
+	for i := range previous {
+		if previous[i].Name == cfg.Name {
+			old = &previous[i]
+		}
+	}
+	if cfg.Password == redacted {
+		if old == nil {
+			return cfg, errors.New("notifier " + cfg.Name + " has a redacted password but no saved one")
+		}
+		cfg.Password = old.Password
+	}
+	if cfg.URL == redacted {
+		if old == nil || old.URL == "" {
+			return cfg, errors.New("notifier " + cfg.Name + " has a redacted URL but no saved one")
+		}
+		cfg.URL = old.URL
+	}
+	for name, value := range cfg.Headers {
+		if value != redacted {
+			continue
+		}
+		if old == nil || old.Headers[name] == "" {
+			return cfg, fmt.Errorf("notifier %s has a redacted %s header but no saved one", cfg.Name, name)
+		}
+		cfg.Headers[name] = old.Headers[name]
+	}
+	return cfg, nil
+}
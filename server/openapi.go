@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiRoute describes an endpoint. The same table registers the handlers and
+// generates the OpenAPI document, so the two cannot drift apart.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Handler http.HandlerFunc
+	// Request is a zero value of the JSON request body, or nil if the
+	// endpoint takes no body.
+	Request interface{}
+	// Produces is the content type of a non-JSON success response.
+	Produces string
+	// Response is a zero value of the JSON body returned on success, or nil
+	// if the endpoint answers 204 No Content.
+	Response interface{}
+	// Status is the success status when it is not 200 OK, e.g. 202 for
+	// endpoints that queue a job.
+	Status int
+}
+
+// apiRoutes lists every endpoint served by the API.
+var apiRoutes []apiRoute
+
+func init() {
+	// Assigned in init because getOpenAPI refers back to apiRoutes.
+	apiRoutes = []apiRoute{
+		{Method: "GET", Path: "/api/v1/health", Summary: "Health check", Handler: getHealth, Response: HealthStatus{}},
+		{Method: "GET", Path: "/api/v1/debug", Summary: "Echo request details", Handler: getDebug, Response: map[string]interface{}{}},
+		{Method: "GET", Path: "/api/v1/cluster/info", Summary: "Cluster summary", Handler: getClusterInfo, Response: ClusterInfo{}},
+		{Method: "GET", Path: "/api/v1/cluster/upgrade", Summary: "Report node versions, a rolling-upgrade order and upgrade progress", Handler: getUpgradeReport, Response: UpgradeReport{}},
+		{Method: "GET", Path: "/api/v1/nodes", Summary: "List cluster nodes", Handler: getNodes, Response: []Node{}},
+		{Method: "GET", Path: "/api/v1/nodes/{id}", Summary: "Get a node's listeners, uptime and view of cluster membership", Handler: getNode, Response: NodeDetail{}},
+		{Method: "GET", Path: "/api/v1/indexes", Summary: "List vector indexes", Handler: getIndexes, Response: []IndexInfo{}},
+		{Method: "GET", Path: "/api/v1/indexes/health", Summary: "Analyze the health of every index", Handler: getIndexesHealth, Response: []IndexHealth{}},
+		{Method: "GET", Path: "/api/v1/indexes/{name}/health", Summary: "Analyze the health of an index", Handler: getIndexHealth, Response: IndexHealth{}},
+		{Method: "GET", Path: "/api/v1/indexes/{name}", Summary: "Get a vector index", Handler: getIndex, Response: IndexInfo{}},
+		{Method: "GET", Path: "/api/v1/benchmarks", Summary: "List stored benchmark results", Handler: listBenchmarks, Response: []BenchmarkResult{}},
+		{Method: "POST", Path: "/api/v1/benchmarks", Summary: "Queue a query latency benchmark", Handler: submitBenchmark, Request: BenchmarkRequest{}, Response: Job{}, Status: http.StatusAccepted},
+		{Method: "GET", Path: "/api/v1/benchmarks/compare", Summary: "Compare benchmark results with a baseline", Handler: getBenchmarkComparison, Response: BenchmarkComparison{}},
+		{Method: "GET", Path: "/api/v1/benchmarks/{id}", Summary: "Get a benchmark result", Handler: getBenchmark, Response: BenchmarkResult{}},
+		{Method: "DELETE", Path: "/api/v1/benchmarks/{id}", Summary: "Delete a benchmark result", Handler: deleteBenchmark},
+		{Method: "POST", Path: "/api/v1/capacity/estimate", Summary: "Estimate the memory and storage a planned index needs", Handler: estimateIndexCapacity, Request: CapacityRequest{}, Response: CapacityEstimate{}},
+		{Method: "GET", Path: "/api/v1/schema", Summary: "Export the live indexes as a schema (?format=yaml for YAML)", Handler: getSchema, Response: Schema{}},
+		{Method: "POST", Path: "/api/v1/schema/plan", Summary: "Diff a YAML or JSON schema against the live indexes", Handler: planSchemaChanges, Request: Schema{}, Response: SchemaPlan{}},
+		{Method: "POST", Path: "/api/v1/schema/apply", Summary: "Apply a reviewed schema plan", Handler: applySchema, Request: SchemaApplyRequest{}, Response: SchemaApplyResult{}},
+		{Method: "GET", Path: "/api/v1/users", Summary: "List users", Handler: getUsers, Response: UserList{}},
+		{Method: "GET", Path: "/api/v1/users/{username}", Summary: "Get a user", Handler: getUser, Response: User{}},
+		{Method: "GET", Path: "/api/v1/roles", Summary: "List roles", Handler: getRoles, Response: RoleList{}},
+		{Method: "GET", Path: "/api/v1/roles/{name}", Summary: "Get a role", Handler: getRole, Response: Role{}},
+		{Method: "POST", Path: "/api/v1/query", Summary: "Run a vector search", Handler: executeQuery, Request: QueryRequest{}, Response: QueryResponse{}},
+		{Method: "POST", Path: "/api/v1/query/compare", Summary: "Run one query against two indexes and compare the results", Handler: compareQuery, Request: CompareQueryRequest{}, Response: CompareQueryResponse{}},
+		{Method: "POST", Path: "/api/v1/query/batch", Summary: "Run many vector searches against one index", Handler: executeBatchQuery, Request: BatchQueryRequest{}, Response: BatchQueryResponse{}},
+		{Method: "GET", Path: "/api/v1/query/history", Summary: "List your recent queries", Handler: getQueryHistory, Response: []HistoryEntry{}},
+		{Method: "DELETE", Path: "/api/v1/query/history", Summary: "Clear your query history", Handler: clearQueryHistory},
+		{Method: "GET", Path: "/api/v1/saved-queries", Summary: "List your saved queries", Handler: listSavedQueries, Response: []SavedQuery{}},
+		{Method: "POST", Path: "/api/v1/saved-queries", Summary: "Save a named query", Handler: createSavedQuery, Request: SavedQueryRequest{}, Response: SavedQuery{}, Status: http.StatusCreated},
+		{Method: "GET", Path: "/api/v1/saved-queries/{id}", Summary: "Get a saved query", Handler: getSavedQuery, Response: SavedQuery{}},
+		{Method: "POST", Path: "/api/v1/saved-queries/{id}/run", Summary: "Re-run a saved query and diff with its last run", Handler: runSavedQuery, Response: SavedQueryRunResponse{}},
+		{Method: "DELETE", Path: "/api/v1/saved-queries/{id}", Summary: "Delete a saved query", Handler: deleteSavedQuery},
+		{Method: "GET", Path: "/api/v1/jobs", Summary: "List jobs", Handler: listJobs, Response: []Job{}},
+		{Method: "POST", Path: "/api/v1/jobs", Summary: "Submit a job", Handler: submitJob, Request: JobRequest{}, Response: Job{}, Status: http.StatusAccepted},
+		{Method: "GET", Path: "/api/v1/jobs/{id}", Summary: "Get a job's status, progress and logs", Handler: getJob, Response: Job{}},
+		{Method: "POST", Path: "/api/v1/jobs/{id}/cancel", Summary: "Cancel a job", Handler: cancelJob, Response: Job{}},
+		{Method: "GET", Path: "/api/v1/alerts", Summary: "List pending and firing alerts", Handler: getAlerts, Response: []Alert{}},
+		{Method: "GET", Path: "/api/v1/alerts/config", Summary: "Get the alert rules and notifiers", Handler: getAlertConfig, Response: AlertConfig{}},
+		{Method: "PUT", Path: "/api/v1/alerts/config", Summary: "Replace the alert rules and notifiers", Handler: putAlertConfig, Request: AlertConfig{}, Response: AlertConfig{}},
+		{Method: "POST", Path: "/api/v1/alerts/notifiers/{name}/test", Summary: "Send a test notification", Handler: testNotifier},
+		{Method: "GET", Path: "/api/v1/config", Summary: "Console configuration", Handler: getConfig, Response: ConfigInfo{}},
+		{Method: "POST", Path: "/api/v1/config/test", Summary: "Test a connection to a cluster stage by stage: DNS, TCP, TLS, auth and a cluster call", Handler: testConnection, Request: ConnectionTestRequest{}, Response: ConnectionTestResponse{}},
+		{Method: "GET", Path: "/api/v1/diagnostics", Summary: "Download a zip of cluster state, redacted asvec config, recent logs and errors for support", Handler: getDiagnostics, Produces: "application/zip"},
+		{Method: "GET", Path: "/api/v1/openapi.json", Summary: "OpenAPI document", Handler: getOpenAPI, Response: map[string]interface{}{}},
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  map[string]interface{}
+)
+
+// openAPISpec returns the OpenAPI 3 document for apiRoutes.
+func openAPISpec() map[string]interface{} {
+	openAPIOnce.Do(func() {
+		openAPIDoc = buildOpenAPISpec(apiRoutes)
+	})
+	return openAPIDoc
+}
+
+func getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("Handling OpenAPI request from %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(openAPISpec()); err != nil {
+		logger.Printf("Error encoding response: %v", err)
+	}
+}
+
+func buildOpenAPISpec(routes []apiRoute) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	errorRef := schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	paths := map[string]interface{}{}
+	for _, route := range routes {
+		responses := map[string]interface{}{
+			"default": jsonContent("Error", errorRef),
+		}
+		switch {
+		case route.Produces != "":
+			responses["200"] = map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					route.Produces: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				},
+			}
+		case route.Response != nil:
+			status := http.StatusOK
+			if route.Status != 0 {
+				status = route.Status
+			}
+			responses[strconv.Itoa(status)] = jsonContent("Success", schemaFor(reflect.TypeOf(route.Response), schemas))
+		default:
+			responses["204"] = map[string]interface{}{"description": "No content"}
+		}
+		op := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": operationID(route),
+			"responses":   responses,
+		}
+		if params := pathParameters(route.Path); len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemaFor(reflect.TypeOf(route.Request), schemas)},
+				},
+			}
+		}
+
+		item, ok := paths[route.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "AVS Console API",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+func jsonContent(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+func operationID(route apiRoute) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool {
+		return r == '/' || r == '.' || r == '{' || r == '}'
+	}) {
+		if part == "api" || part == "v1" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// pathParameters describes the {name} segments of an OpenAPI path.
+func pathParameters(path string) []map[string]interface{} {
+	var params []map[string]interface{}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema for t. Named structs are added to schemas
+// and referenced by name.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate.
+			schemas[t.Name()] = map[string]interface{}{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return ref
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t, schemas)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	// interface{} and anything else accepts any JSON value
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
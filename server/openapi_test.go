@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAsvec is a stand-in for the asvec CLI that prints canned output for the
+// commands the handlers run.
+const fakeAsvec = `#!/bin/sh
+case "$*" in
+"node ls --format 1")
+	printf 'Nodes\n,Node,Roles,Endpoint,Peers,Version\n1,139637976803088,INDEXER,127.0.0.1:5000,,1.1.0\n'
+	;;
+"index ls --verbose --format 1")
+	printf 'Indexes\n,Name,Namespace,Set,Field,Dimensions,Distance Metric,Unmerged,Vector Records,Size,Unmerged %%,Mode,Status,Vertices,Labels\n1,idx,test,vectors,vec,2,COSINE,3,1000,1 MB,0.3%%,DISTRIBUTED,READY,1000,map[]\n'
+	;;
+"node ls --verbose --format 1")
+	printf 'Nodes\n,Node,Roles,Endpoint,Peers,Version,Listener Name,Seed,Uptime\n1,139637976803088,INDEXER,127.0.0.1:5000,"[139637976803089:127.0.0.2:5000]",1.1.0,default,true,2h\n2,139637976803089,INDEXER,127.0.0.2:5000,"[139637976803088:127.0.0.1:5000]",1.1.0,default,false,2h\n'
+	;;
+"cluster info")
+	echo '{"totalVectors": 1000}'
+	;;
+"user ls --format 1")
+	printf 'Users\n,User,Roles\n1,admin,"admin, read-write"\n'
+	;;
+"role ls --format 1")
+	printf 'Roles\n,Roles\n1,admin\n2,read-write\n'
+	;;
+"query -i idx -k 2"*)
+	echo '[{"id":"a","similarity":0.9,"metadata":"{}"},{"id":"b","similarity":0.8,"metadata":"{}"}]'
+	;;
+"--version")
+	echo 'asvec version 3.0.0'
+	;;
+esac
+`
+
+func installFakeAsvec(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(fakeAsvec), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// TestHandlersMatchOpenAPI calls every route against a fake asvec and checks
+// that the success response conforms to the schema in /api/openapi.json. It
+// checks the shape of responses only; what each endpoint does is tested
+// next to the feature, with fixtures of its own.
+func TestHandlersMatchOpenAPI(t *testing.T) {
+	installFakeAsvec(t)
+	serverConfig.DataDir = t.TempDir()
+	jobs = newJobManager(serverConfig.DataDir, nil)
+	t.Cleanup(jobs.Stop)
+	job, err := jobs.Submit("benchmark", map[string]interface{}{"index": "idx", "queries": [][]float64{{0.1, 0.2}}, "limit": 2, "duration": "100ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	benchmarks = newBenchmarkStore(serverConfig.DataDir)
+	for _, id := range []string{"bench1", "bench2", "bench3"} {
+		benchmarks.Add(BenchmarkResult{ID: id, Index: "idx", Parameters: map[string]string{}, Histogram: latencyHistogram(nil), Errors: map[string]int{}})
+	}
+	queries = newQueryStore(serverConfig.DataDir, 10)
+	alerts = newAlertManager(serverConfig.DataDir, time.Hour)
+	upgrades = newUpgradeTracker(serverConfig.DataDir)
+	hook, _ := captureServer(t)
+	alertConfig := `{"rules":[{"name":"nodes-down","metric":"node_count","operator":"<","threshold":3,"for":"1m"}],"notifiers":[{"name":"hook","type":"webhook","url":"` + hook.URL + `"}]}`
+	if err := alerts.SetConfig(AlertConfig{Notifiers: []NotifierConfig{{Name: "hook", Type: "webhook", URL: hook.URL}}}); err != nil {
+		t.Fatal(err)
+	}
+	queries.Save(SavedQuery{ID: "saved1", Name: "first", Owner: anonymousUser, Query: QueryRequest{Index: "idx", Query: []float64{0.1, 0.2}, Limit: 2}})
+
+	rec := httptest.NewRecorder()
+	getOpenAPI(rec, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	var spec map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	paths := spec["paths"].(map[string]interface{})
+
+	requestBodies := map[string]string{
+		"/api/v1/query":             `{"index":"idx","query":[0.1,0.2],"limit":2}`,
+		"/api/v1/benchmarks":        `{"index":"idx","duration":"100ms","queries":[[0.1,0.2]]}`,
+		"/api/v1/query/batch":       `{"index":"idx","queries":[[0.1,0.2],[0.3]],"limit":2,"includeBins":["title"]}`,
+		"/api/v1/query/compare":     `{"baseline":"idx","candidate":"idx","query":[0.1,0.2],"limit":2}`,
+		"/api/v1/saved-queries":     `{"name":"near k1","query":{"index":"idx","key":"k1","limit":1}}`,
+		"/api/v1/schema/plan":       "indexes:\n  - name: idx\n    namespace: test\n    set: vectors\n    field: vec\n    dimensions: 2\n  - name: idx2\n    namespace: test\n    field: vec\n    dimensions: 4\n    distanceMetric: dot_product\n    parameters:\n      hnsw-m: \"32\"\n",
+		"/api/v1/schema/apply":      `{"schema":{"indexes":[{"name":"idx","namespace":"test","field":"vec","dimensions":2}]},"planHash":"` + planSchema(Schema{}, nil).Hash + `"}`,
+		"/api/v1/capacity/estimate": `{"dimensions":768,"records":1000000,"m":16}`,
+		"/api/v1/config/test":       `{"host":"` + strings.TrimPrefix(hook.URL, "http://") + `"}`,
+		"/api/v1/alerts/config":     alertConfig,
+		"/api/v1/jobs":              `{"type":"benchmark","params":{"index":"idx","queries":[[0.1,0.2]],"limit":2,"duration":"100ms"}}`,
+	}
+	// Concrete paths for routes with parameters, naming objects the fake
+	// asvec knows about
+	examplePaths := map[string]string{
+		"/api/v1/indexes/{name}":               "/api/v1/indexes/idx",
+		"/api/v1/nodes/{id}":                   "/api/v1/nodes/139637976803088",
+		"/api/v1/users/{username}":             "/api/v1/users/admin",
+		"/api/v1/roles/{name}":                 "/api/v1/roles/admin",
+		"/api/v1/indexes/{name}/health":        "/api/v1/indexes/idx/health",
+		"/api/v1/benchmarks/{id}":              "/api/v1/benchmarks/bench2",
+		"/api/v1/benchmarks/compare":           "/api/v1/benchmarks/compare?ids=bench1,bench3",
+		"/api/v1/saved-queries/{id}":           "/api/v1/saved-queries/saved1",
+		"/api/v1/saved-queries/{id}/run":       "/api/v1/saved-queries/saved1/run",
+		"/api/v1/alerts/notifiers/{name}/test": "/api/v1/alerts/notifiers/hook/test",
+		"/api/v1/jobs/{id}":                    "/api/v1/jobs/" + job.ID,
+		"/api/v1/jobs/{id}/cancel":             "/api/v1/jobs/" + job.ID + "/cancel",
+	}
+	handler := withRequestID(newAPIRouter().ServeHTTP)
+
+	for _, route := range apiRoutes {
+		t.Run(route.Method+" "+route.Path, func(t *testing.T) {
+			op, ok := paths[route.Path].(map[string]interface{})[strings.ToLower(route.Method)].(map[string]interface{})
+			if !ok {
+				t.Fatalf("route missing from spec")
+			}
+			responses := op["responses"].(map[string]interface{})
+
+			path := route.Path
+			if example, ok := examplePaths[route.Path]; ok {
+				path = example
+			}
+			if strings.Contains(path, "{") {
+				t.Fatalf("no example path for %s", route.Path)
+			}
+			req := httptest.NewRequest(route.Method, path, strings.NewReader(requestBodies[route.Path]))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if _, ok := responses["204"]; ok {
+				if rec.Code != http.StatusNoContent {
+					t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			status := "200"
+			for _, documented := range []string{"201", "202"} {
+				if _, ok := responses[documented]; ok {
+					status = documented
+				}
+			}
+			if got := strconv.Itoa(rec.Code); got != status {
+				t.Fatalf("status = %s, want %s, body = %s", got, status, rec.Body.String())
+			}
+			if route.Produces != "" {
+				if got := rec.Header().Get("Content-Type"); got != route.Produces {
+					t.Errorf("Content-Type = %q, want %q", got, route.Produces)
+				}
+				return
+			}
+			schema := responses[status].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+			var body interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response is not JSON: %v", err)
+			}
+			for _, problem := range validateSchema(body, schema, schemas, "$") {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+// TestErrorsMatchOpenAPI checks that error responses use the documented
+// envelope.
+func TestErrorsMatchOpenAPI(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	spec := openAPISpec()
+	raw, _ := json.Marshal(spec)
+	var decoded map[string]interface{}
+	json.Unmarshal(raw, &decoded)
+	schemas := decoded["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	rec := httptest.NewRecorder()
+	withRequestID(getIndexes)(rec, httptest.NewRequest("GET", "/api/indexes", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	ref := map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"}
+	for _, problem := range validateSchema(body, ref, schemas, "$") {
+		t.Error(problem)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte(string(ErrCodeCLINotInstalled))) {
+		t.Errorf("body %s does not carry code %s", rec.Body.String(), ErrCodeCLINotInstalled)
+	}
+}
+
+// validateSchema checks value against the subset of JSON Schema produced by
+// schemaFor and returns one message per mismatch.
+func validateSchema(value interface{}, schema, schemas map[string]interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := schemas[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", path, ref)}
+		}
+		return validateSchema(value, resolved, schemas, path)
+	}
+
+	var problems []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %T", path, value)}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := obj[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if propSchema, ok := properties[key].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(obj[key], propSchema, schemas, path+"."+key)...)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					problems = append(problems, fmt.Sprintf("%s: unexpected property %q", path, key))
+				}
+			case map[string]interface{}:
+				problems = append(problems, validateSchema(obj[key], extra, schemas, path+"."+key)...)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %T", path, value)}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range arr {
+			problems = append(problems, validateSchema(item, items, schemas, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected string, got %T", path, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected boolean, got %T", path, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, fmt.Sprintf("%s: expected integer, got %v", path, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected number, got %T", path, value))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// checkDimensions rejects vectors whose length differs from the index's
+func checkDimensions(index IndexInfo, vector []float64) error {
+	if len(vector) != index.Dimensions {
+		return &APIError{
+			Code:    ErrCodeBadRequest,
+			Message: "vector dimensions do not match the index",
+			Details: fmt.Sprintf("index %s expects %d dimensions, got %d", index.Name, index.Dimensions, len(vector)),
+		}
+	}
+	return nil
+}
+
+// maxBatchQueries bounds the number of vectors in one batch query.
+const maxBatchQueries = 1000
+
+// BatchQueryRequest runs many searches with shared options.
+type BatchQueryRequest struct {
+	Index       string                 `json:"index"`
+	Queries     [][]float64            `json:"queries"`
+	Limit       int                    `json:"limit,omitempty"`
+	Ef          int                    `json:"ef,omitempty"`
+	Filter      map[string]interface{} `json:"filter,omitempty"`
+	IncludeBins []string               `json:"includeBins,omitempty"`
+	// Parallelism bounds the searches run at once. It defaults to 4 and is
+	// capped at AVS_CONSOLE_MAX_BATCH_CALLS, the slots batch searches run on
+	// apart from interactive calls.
+	Parallelism int `json:"parallelism,omitempty"`
+}
+
+// BatchQueryResult is the outcome of one search in a batch. A failed search
+// carries Error and no results.
+type BatchQueryResult struct {
+	Query         int           `json:"query"`
+	Results       []QueryResult `json:"results"`
+	ExecutionTime float64       `json:"executionTime"`
+	Error         *APIError     `json:"error,omitempty"`
+}
+
+// BatchQueryResponse holds the results of a batch in request order.
+type BatchQueryResponse struct {
+	Results       []BatchQueryResult `json:"results"`
+	Succeeded     int                `json:"succeeded"`
+	Failed        int                `json:"failed"`
+	ExecutionTime float64            `json:"executionTime"`
+}
+
+// executeBatchQuery runs every vector of a batch against one index. A
+// failing vector is reported in its own result and does not stop the rest.
+func executeBatchQuery(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling batch query request from %s", r.RemoteAddr)
+
+	var batch BatchQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	if len(batch.Queries) == 0 || len(batch.Queries) > maxBatchQueries {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "a batch must hold between 1 and %d queries", maxBatchQueries))
+		return
+	}
+	if batch.Parallelism < 1 {
+		batch.Parallelism = 4
+	}
+	batch.Parallelism = min(batch.Parallelism, serverConfig.MaxBatchCalls)
+
+	index, err := findIndex(r.Context(), batch.Index)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := runBatchQuery(r.Context(), index, batch)
+	logger.Printf("Batch of %d queries on %s: %d succeeded, %d failed",
+		len(batch.Queries), index.Name, response.Succeeded, response.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func runBatchQuery(ctx context.Context, index IndexInfo, batch BatchQueryRequest) BatchQueryResponse {
+	start := time.Now()
+	response := BatchQueryResponse{Results: make([]BatchQueryResult, len(batch.Queries))}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < batch.Parallelism; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result := BatchQueryResult{Query: i, Results: []QueryResult{}}
+				err := checkDimensions(index, batch.Queries[i])
+				if err == nil {
+					var results []QueryResult
+					var elapsed time.Duration
+					results, elapsed, err = runQuery(ctx, asvec.ExecBatch, QueryRequest{
+						Index:       index.Name,
+						Query:       batch.Queries[i],
+						Limit:       batch.Limit,
+						Ef:          batch.Ef,
+						Filter:      batch.Filter,
+						IncludeBins: batch.IncludeBins,
+					})
+					result.ExecutionTime = elapsed.Seconds()
+					if err == nil {
+						result.Results = results
+					}
+				}
+				if err != nil {
+					result.Error = asAPIError(err)
+				}
+				response.Results[i] = result
+			}
+		}()
+	}
+	for i := range batch.Queries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if result.Error != nil {
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+	}
+	response.ExecutionTime = time.Since(start).Seconds()
+	return response
+}
+
+// filterResults applies a metadata filter and bin selection to search
+// results. Result metadata is a JSON object; results whose metadata cannot
+// be decoded never match a filter.
+func filterResults(results []QueryResult, filter map[string]interface{}, includeBins []string) []QueryResult {
+	if len(filter) == 0 && len(includeBins) == 0 {
+		return results
+	}
+
+	kept := make([]QueryResult, 0, len(results))
+	for _, result := range results {
+		var bins map[string]interface{}
+		if err := json.Unmarshal([]byte(result.Metadata), &bins); err != nil {
+			if len(filter) == 0 {
+				kept = append(kept, result)
+			}
+			continue
+		}
+		if !matchesFilter(bins, filter) {
+			continue
+		}
+		if len(includeBins) > 0 {
+			selected := make(map[string]interface{}, len(includeBins))
+			for _, name := range includeBins {
+				if value, ok := bins[name]; ok {
+					selected[name] = value
+				}
+			}
+			encoded, _ := json.Marshal(selected)
+			result.Metadata = string(encoded)
+		}
+		kept = append(kept, result)
+	}
+	return kept
+}
+
+// matchesFilter reports whether every filter bin equals the record's bin.
+// Values are compared after a JSON round trip so 1 and 1.0 are equal.
+func matchesFilter(bins, filter map[string]interface{}) bool {
+	for name, want := range filter {
+		got, ok := bins[name]
+		if !ok {
+			return false
+		}
+		if !reflect.DeepEqual(normalizeJSON(got), normalizeJSON(want)) {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeJSON(v interface{}) interface{} {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	json.Unmarshal(encoded, &out)
+	return out
+}
+
+// CompareQueryRequest runs one query against two indexes, such as the old
+// and new index of a migration. Exactly one of Query, Key or Text is given;
+// a Key is resolved to a vector separately on each index, so the indexes may
+// use different fields or embedding models.
+type CompareQueryRequest struct {
+	Baseline  string    `json:"baseline"`
+	Candidate string    `json:"candidate"`
+	Query     []float64 `json:"query,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Set       string    `json:"set,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Limit     int       `json:"limit,omitempty"`
+	Ef        int       `json:"ef,omitempty"`
+}
+
+// ComparedSide is the result of the query on one index.
+type ComparedSide struct {
+	Index         string        `json:"index"`
+	Results       []QueryResult `json:"results"`
+	ExecutionTime float64       `json:"executionTime"`
+}
+
+// ComparedResult is one record found by either index. Ranks are 1-based and
+// absent for the index that did not return the record. RankShift is the
+// baseline rank minus the candidate rank, so a positive shift means the
+// candidate ranks the record higher.
+type ComparedResult struct {
+	ID                  string   `json:"id"`
+	BaselineRank        *int     `json:"baselineRank,omitempty"`
+	CandidateRank       *int     `json:"candidateRank,omitempty"`
+	RankShift           *int     `json:"rankShift,omitempty"`
+	BaselineSimilarity  *float64 `json:"baselineSimilarity,omitempty"`
+	CandidateSimilarity *float64 `json:"candidateSimilarity,omitempty"`
+}
+
+// CompareQueryResponse merges the results of both indexes. Merged lists the
+// baseline results in order followed by those only the candidate returned.
+type CompareQueryResponse struct {
+	Baseline  ComparedSide     `json:"baseline"`
+	Candidate ComparedSide     `json:"candidate"`
+	Merged    []ComparedResult `json:"merged"`
+	K         int              `json:"k"`
+	// OverlapAtK is the share of the k results returned by both indexes
+	OverlapAtK float64 `json:"overlapAtK"`
+	// Jaccard is the size of the intersection over the size of the union
+	Jaccard float64 `json:"jaccard"`
+	// LatencyDifference is the candidate's execution time minus the
+	// baseline's, in seconds
+	LatencyDifference float64 `json:"latencyDifference"`
+}
+
+// compareQuery runs the same query on two indexes at once and reports how
+// far their results agree.
+func compareQuery(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling query comparison request from %s", r.RemoteAddr)
+
+	var compare CompareQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&compare); err != nil {
+		writeError(w, r, &APIError{Code: ErrCodeBadRequest, Message: "invalid request body", Details: err.Error()})
+		return
+	}
+	if compare.Baseline == "" || compare.Candidate == "" {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "baseline and candidate indexes are required"))
+		return
+	}
+	if len(compare.Query) == 0 && compare.Key == "" && compare.Text == "" {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "give a query vector, a record key or text"))
+		return
+	}
+	if compare.Limit < 1 {
+		compare.Limit = 10
+	}
+
+	response, err := runCompareQuery(r.Context(), compare)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logger.Printf("Compared %s with %s: overlap@%d %.2f, Jaccard %.2f",
+		compare.Baseline, compare.Candidate, response.K, response.OverlapAtK, response.Jaccard)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func runCompareQuery(ctx context.Context, compare CompareQueryRequest) (*CompareQueryResponse, error) {
+	names := [2]string{compare.Baseline, compare.Candidate}
+	var sides [2]ComparedSide
+	var errs [2]error
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			if len(compare.Query) > 0 {
+				index, err := findIndex(ctx, name)
+				if err == nil {
+					err = checkDimensions(index, compare.Query)
+				}
+				if err != nil {
+					errs[i] = err
+					return
+				}
+			}
+			results, elapsed, err := runQuery(ctx, runShared, QueryRequest{
+				Index: name,
+				Query: compare.Query,
+				Key:   compare.Key,
+				Set:   compare.Set,
+				Text:  compare.Text,
+				Limit: compare.Limit,
+				Ef:    compare.Ef,
+			})
+			sides[i] = ComparedSide{Index: name, Results: results, ExecutionTime: elapsed.Seconds()}
+			errs[i] = err
+		}(i, name)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &CompareQueryResponse{
+		Baseline:          sides[0],
+		Candidate:         sides[1],
+		Merged:            mergeResults(sides[0].Results, sides[1].Results),
+		K:                 compare.Limit,
+		LatencyDifference: sides[1].ExecutionTime - sides[0].ExecutionTime,
+	}
+	shared := 0
+	for _, result := range response.Merged {
+		if result.BaselineRank != nil && result.CandidateRank != nil {
+			shared++
+		}
+	}
+	response.OverlapAtK = float64(shared) / float64(compare.Limit)
+	if len(response.Merged) > 0 {
+		response.Jaccard = float64(shared) / float64(len(response.Merged))
+	}
+	return response, nil
+}
+
+// mergeResults lines up two result lists by record ID.
+func mergeResults(baseline, candidate []QueryResult) []ComparedResult {
+	merged := make([]ComparedResult, 0, len(baseline)+len(candidate))
+	positions := make(map[string]int, len(baseline))
+	for i, result := range baseline {
+		rank, similarity := i+1, result.Similarity
+		positions[result.ID] = len(merged)
+		merged = append(merged, ComparedResult{ID: result.ID, BaselineRank: &rank, BaselineSimilarity: &similarity})
+	}
+	for i, result := range candidate {
+		rank, similarity := i+1, result.Similarity
+		position, ok := positions[result.ID]
+		if !ok {
+			merged = append(merged, ComparedResult{ID: result.ID, CandidateRank: &rank, CandidateSimilarity: &similarity})
+			continue
+		}
+		shift := *merged[position].BaselineRank - rank
+		merged[position].CandidateRank = &rank
+		merged[position].CandidateSimilarity = &similarity
+		merged[position].RankShift = &shift
+	}
+	return merged
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// filterAsvec installs an asvec whose searches return the k nearest of
+// total records, every fifth of which has team "a". Searched values of k
+// are appended to the returned file.
+func filterAsvec(t *testing.T, total int) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+k=$5
+echo $k >> ` + calls + `
+[ $k -gt ` + strconv.Itoa(total) + ` ] && k=` + strconv.Itoa(total) + `
+printf '['
+i=1
+while [ $i -le $k ]; do
+	team=b
+	[ $((i % 5)) -eq 0 ] && team=a
+	[ $i -gt 1 ] && printf ','
+	printf '{"id":"r%d","similarity":1,"metadata":"{\\"team\\":\\"%s\\"}"}' $i $team
+	i=$((i + 1))
+done
+echo ']'
+`
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func TestRunQueryFetchesUntilFilterMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  int
+		filter map[string]interface{}
+		want   int
+		ks     string
+	}{
+		{"no filter", 100, nil, 3, "3\n"},
+		{"matches in the first batch", 100, map[string]interface{}{"team": "b"}, 3, "3\n"},
+		{"fetches more", 100, map[string]interface{}{"team": "a"}, 3, "3\n12\n48\n"},
+		{"index runs out", 12, map[string]interface{}{"team": "a"}, 2, "3\n12\n48\n"},
+		{"no matches within the bound", 5000, map[string]interface{}{"team": "c"}, 0, "3\n12\n48\n192\n768\n1000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := filterAsvec(t, tt.total)
+			results, _, err := runQuery(context.Background(), runShared, QueryRequest{Index: "idx", Query: []float64{1}, Limit: 3, Filter: tt.filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != tt.want {
+				t.Errorf("results = %+v", results)
+			}
+			if ks, _ := os.ReadFile(calls); string(ks) != tt.ks {
+				t.Errorf("searched k = %q, want %q", ks, tt.ks)
+			}
+		})
+	}
+}
+
+func TestRunQueryByRecordKey(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\n" +
+		`echo '[{"id":"seed","similarity":1},{"id":"r1","similarity":0.9},{"id":"r2","similarity":0.8}]'` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		includeSeed bool
+		args        string
+		ids         []string
+	}{
+		{false, "query -i idx -k 3 --key seed --set docs\n", []string{"r1", "r2"}},
+		{true, "query -i idx -k 2 --key seed --set docs\n", []string{"seed", "r1"}},
+	}
+	for _, tt := range tests {
+		os.Remove(calls)
+		results, _, err := runQuery(context.Background(), runShared, QueryRequest{Index: "idx", Key: "seed", Set: "docs", Limit: 2, IncludeSeed: tt.includeSeed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("includeSeed=%v: ids = %v, want %v", tt.includeSeed, ids, tt.ids)
+		}
+		if args, _ := os.ReadFile(calls); string(args) != tt.args {
+			t.Errorf("includeSeed=%v: asvec %q, want %q", tt.includeSeed, args, tt.args)
+		}
+	}
+}
+
+func TestRunBatchQuery(t *testing.T) {
+	filterAsvec(t, 100)
+	index := IndexInfo{Name: "idx", Dimensions: 1}
+	batch := BatchQueryRequest{
+		Queries:     [][]float64{{1}, {1, 2}, {3}},
+		Limit:       2,
+		Filter:      map[string]interface{}{"team": "a"},
+		IncludeBins: []string{"missing"},
+		Parallelism: 2,
+	}
+	response := runBatchQuery(context.Background(), index, batch)
+	if response.Succeeded != 2 || response.Failed != 1 || len(response.Results) != 3 {
+		t.Fatalf("response = %+v", response)
+	}
+	for i, result := range response.Results {
+		if result.Query != i {
+			t.Errorf("result %d is for query %d", i, result.Query)
+		}
+	}
+	if failed := response.Results[1]; failed.Error == nil || failed.Error.Code != ErrCodeBadRequest || len(failed.Results) != 0 {
+		t.Errorf("failed result = %+v", failed)
+	}
+	for _, result := range []BatchQueryResult{response.Results[0], response.Results[2]} {
+		if len(result.Results) != 2 || result.Results[0].ID != "r5" || result.Results[0].Metadata != "{}" {
+			t.Errorf("result = %+v", result)
+		}
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	results := func(ids ...string) []QueryResult {
+		var list []QueryResult
+		for i, id := range ids {
+			list = append(list, QueryResult{ID: id, Similarity: 1 - float64(i)/10})
+		}
+		return list
+	}
+	merged := mergeResults(results("a", "b", "c"), results("c", "a", "d"))
+	type row struct {
+		id                  string
+		baseline, candidate int
+		shift               int
+	}
+	var got []row
+	for _, m := range merged {
+		r := row{id: m.ID}
+		if m.BaselineRank != nil {
+			r.baseline = *m.BaselineRank
+		}
+		if m.CandidateRank != nil {
+			r.candidate = *m.CandidateRank
+		}
+		if m.RankShift != nil {
+			r.shift = *m.RankShift
+		}
+		if (m.BaselineRank == nil) != (m.BaselineSimilarity == nil) || (m.CandidateRank == nil) != (m.CandidateSimilarity == nil) {
+			t.Errorf("%s: ranks and similarities disagree", m.ID)
+		}
+		got = append(got, r)
+	}
+	want := []row{{"a", 1, 2, -1}, {"b", 2, 0, 0}, {"c", 3, 1, 2}, {"d", 0, 3, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged = %+v, want %+v", got, want)
+	}
+}
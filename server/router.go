@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// apiVersionPrefix is the prefix of every versioned route in apiRoutes.
+const apiVersionPrefix = "/api/v1"
+
+// legacyPrefix is the unversioned prefix kept as a deprecated alias.
+const legacyPrefix = "/api"
+
+// apiRouter serves apiRoutes through an http.ServeMux. Requests that match
+// no route get the API's JSON errors instead of the mux's plain text ones.
+type apiRouter struct {
+	mux *http.ServeMux
+}
+
+// newAPIRouter registers every route in apiRoutes under /api/v1 and, for
+// backwards compatibility, under the deprecated unversioned /api prefix.
+func newAPIRouter() *apiRouter {
+	mux := http.NewServeMux()
+	for _, route := range apiRoutes {
+		mux.HandleFunc(route.Method+" "+route.Path, route.Handler)
+
+		legacyPath := legacyPrefix + strings.TrimPrefix(route.Path, apiVersionPrefix)
+		mux.HandleFunc(route.Method+" "+legacyPath, deprecated(route.Handler))
+	}
+	return &apiRouter{mux: mux}
+}
+
+func (rt *apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := rt.mux.Handler(r); pattern != "" {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+
+	// Let the mux tell a wrong method from a wrong path, then answer in JSON
+	probe := &statusProbe{header: http.Header{}}
+	rt.mux.ServeHTTP(probe, r)
+	if probe.status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", probe.header.Get("Allow"))
+		writeError(w, r, newAPIError(ErrCodeMethodNotAllowed, "method %s not allowed for %s", r.Method, r.URL.Path))
+		return
+	}
+	writeError(w, r, newAPIError(ErrCodeNotFound, "no route for %s", r.URL.Path))
+}
+
+// statusProbe is a ResponseWriter that keeps the status and headers of a
+// response and discards its body.
+type statusProbe struct {
+	header http.Header
+	status int
+}
+
+func (p *statusProbe) Header() http.Header         { return p.header }
+func (p *statusProbe) Write(b []byte) (int, error) { return len(b), nil }
+func (p *statusProbe) WriteHeader(status int)      { p.status = status }
+
+// deprecated marks responses from an unversioned alias with the headers
+// clients use to discover its replacement.
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		successor := apiVersionPrefix + strings.TrimPrefix(r.URL.Path, legacyPrefix)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIRouter(t *testing.T) {
+	defer func(routes []apiRoute) { apiRoutes = routes }(apiRoutes)
+	echo := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + r.PathValue("id")))
+		}
+	}
+	apiRoutes = []apiRoute{
+		{Method: "GET", Path: "/api/v1/things/stats", Handler: echo("stats")},
+		{Method: "GET", Path: "/api/v1/things/{id}", Handler: echo("get")},
+		{Method: "DELETE", Path: "/api/v1/things/{id}", Handler: echo("delete")},
+		{Method: "POST", Path: "/api/v1/things", Handler: echo("create")},
+	}
+	router := newAPIRouter()
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string // response body, or error code
+		allow        string
+		successor    string // Link successor of a deprecated alias
+	}{
+		{"GET", "/api/v1/things/42", 200, "get:42", "", ""},
+		{"HEAD", "/api/v1/things/42", 200, "", "", ""},
+		{"GET", "/api/v1/things/stats", 200, "stats:", "", ""},
+		{"DELETE", "/api/v1/things/42", 200, "delete:42", "", ""},
+		{"GET", "/api/v1/things/a%2Fb", 200, "get:a/b", "", ""},
+		{"GET", "/api/things/42", 200, "get:42", "", "/api/v1/things/42"},
+		{"POST", "/api/things", 200, "create:", "", "/api/v1/things"},
+		{"GET", "/api/v1/things", 405, "method_not_allowed", "POST", ""},
+		{"PUT", "/api/v1/things/42", 405, "method_not_allowed", "DELETE, GET, HEAD", ""},
+		{"PUT", "/api/things/42", 405, "method_not_allowed", "DELETE, GET, HEAD", ""},
+		{"GET", "/api/v1/others", 404, "not_found", "", ""},
+		{"GET", "/api/v1/things/42/more", 404, "not_found", "", ""},
+		{"GET", "/api/v2/things/42", 404, "not_found", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body = %s", rec.Code, tt.status, rec.Body)
+			}
+			body := rec.Body.String()
+			if rec.Code >= 400 {
+				var response struct {
+					Error APIError `json:"error"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+					t.Fatalf("error body %q: %v", body, err)
+				}
+				body = string(response.Error.Code)
+			}
+			if tt.method != "HEAD" && body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			deprecation, link := rec.Header().Get("Deprecation"), rec.Header().Get("Link")
+			switch {
+			case tt.successor == "" && (deprecation != "" || link != ""):
+				t.Errorf("Deprecation = %q, Link = %q on a current route", deprecation, link)
+			case tt.successor != "" && (deprecation != "true" || link != "<"+tt.successor+`>; rel="successor-version"`):
+				t.Errorf("Deprecation = %q, Link = %q", deprecation, link)
+			}
+		})
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSchemaSize bounds the size of a schema file in a request body.
+const maxSchemaSize = 4 << 20
+
+// immutableParameters are HNSW parameters fixed when an index is built;
+// changing one means dropping and recreating the index.
+var immutableParameters = map[string]bool{
+	"hnsw-m":               true,
+	"hnsw-ef-construction": true,
+}
+
+// IndexDefinition is the declarative form of an index. Its fields mirror
+// IndexInfo. Parameters are keyed by asvec flag name, e.g. "hnsw-m" or
+// "hnsw-ef-construction"; names as printed by `asvec index ls`, such as
+// "Ef Construction", are accepted too.
+type IndexDefinition struct {
+	Name           string            `json:"name" yaml:"name"`
+	Namespace      string            `json:"namespace" yaml:"namespace"`
+	Set            string            `json:"set,omitempty" yaml:"set,omitempty"`
+	Field          string            `json:"field" yaml:"field"`
+	Dimensions     int               `json:"dimensions" yaml:"dimensions"`
+	DistanceMetric string            `json:"distanceMetric,omitempty" yaml:"distanceMetric,omitempty"`
+	Storage        string            `json:"storage,omitempty" yaml:"storage,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Parameters     map[string]string `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+}
+
+// Schema is the set of indexes a cluster should have.
+type Schema struct {
+	Indexes []IndexDefinition `json:"indexes" yaml:"indexes"`
+}
+
+// SchemaFieldDiff is one field that differs between the live index and its
+// definition.
+type SchemaFieldDiff struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// SchemaChange is one step of a plan. Action is "create", "update",
+// "replace" (drop and recreate, for changes an index cannot take in place)
+// or "drop".
+type SchemaChange struct {
+	Action      string            `json:"action"`
+	Index       string            `json:"index"`
+	Diffs       []SchemaFieldDiff `json:"diffs,omitempty"`
+	Destructive bool              `json:"destructive"`
+	Definition  *IndexDefinition  `json:"definition,omitempty"`
+}
+
+// SchemaPlan lists the changes that turn the live cluster into a schema.
+// Hash identifies the plan, so that apply can refuse to run when the
+// cluster has changed since the plan was reviewed.
+type SchemaPlan struct {
+	Hash        string         `json:"hash"`
+	Changes     []SchemaChange `json:"changes"`
+	Creates     int            `json:"creates"`
+	Updates     int            `json:"updates"`
+	Replaces    int            `json:"replaces"`
+	Drops       int            `json:"drops"`
+	Destructive bool           `json:"destructive"`
+}
+
+// SchemaApplyRequest applies a schema. PlanHash must be the hash of the plan
+// that was reviewed; AllowDestructive must be set for plans that drop or
+// replace indexes.
+type SchemaApplyRequest struct {
+	Schema           Schema `json:"schema" yaml:"schema"`
+	PlanHash         string `json:"planHash" yaml:"planHash"`
+	AllowDestructive bool   `json:"allowDestructive,omitempty" yaml:"allowDestructive,omitempty"`
+}
+
+// SchemaChangeResult is the outcome of one change. Status is "applied",
+// "failed", or "skipped" for changes after a failure.
+type SchemaChangeResult struct {
+	Change SchemaChange `json:"change"`
+	Status string       `json:"status"`
+	Error  *APIError    `json:"error,omitempty"`
+}
+
+// SchemaApplyResult reports how far an apply got.
+type SchemaApplyResult struct {
+	Hash    string               `json:"hash"`
+	Results []SchemaChangeResult `json:"results"`
+	Applied int                  `json:"applied"`
+	Failed  int                  `json:"failed"`
+	Skipped int                  `json:"skipped"`
+}
+
+// definitionOf turns a live index into its declarative form.
+func definitionOf(index IndexInfo) IndexDefinition {
+	def := IndexDefinition{
+		Name:           index.Name,
+		Namespace:      index.Namespace,
+		Set:            index.Set,
+		Field:          index.Field,
+		Dimensions:     index.Dimensions,
+		DistanceMetric: index.DistanceMetric,
+		Labels:         index.Labels,
+		Parameters:     normalizeParameters(index.Parameters),
+	}
+	if index.Storage != "" && index.Storage != index.Namespace {
+		def.Storage = index.Storage
+	}
+	return def
+}
+
+// normalizeParameters keys parameters by asvec flag name.
+func normalizeParameters(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(params))
+	for key, value := range params {
+		flag := strings.Join(strings.Fields(strings.ToLower(key)), "-")
+		if !strings.HasPrefix(flag, "hnsw-") {
+			flag = "hnsw-" + flag
+		}
+		normalized[flag] = value
+	}
+	return normalized
+}
+
+// checkSchema checks that a schema is complete and names each index once.
+func checkSchema(schema Schema) error {
+	seen := map[string]bool{}
+	for i, def := range schema.Indexes {
+		if def.Name == "" || def.Namespace == "" || def.Field == "" || def.Dimensions < 1 {
+			return newAPIError(ErrCodeBadRequest, "index %d needs a name, namespace, field and positive dimensions", i+1)
+		}
+		if seen[def.Name] {
+			return newAPIError(ErrCodeBadRequest, "index %q is defined more than once", def.Name)
+		}
+		seen[def.Name] = true
+	}
+	return nil
+}
+
+// planSchema diffs a schema against the live indexes. Fields a definition
+// leaves empty, and parameters it does not list, are not compared. Storage
+// is compared with the live storage namespace, not the exported form, and
+// not at all when asvec did not print it.
+func planSchema(schema Schema, live []IndexInfo) SchemaPlan {
+	existing := make(map[string]IndexDefinition, len(live))
+	storage := make(map[string]string, len(live))
+	for _, index := range live {
+		existing[index.Name] = definitionOf(index)
+		storage[index.Name] = index.Storage
+	}
+
+	plan := SchemaPlan{Changes: []SchemaChange{}}
+	wanted := map[string]bool{}
+	for _, def := range schema.Indexes {
+		def := def
+		def.Parameters = normalizeParameters(def.Parameters)
+		wanted[def.Name] = true
+		current, ok := existing[def.Name]
+		if !ok {
+			plan.Changes = append(plan.Changes, SchemaChange{Action: "create", Index: def.Name, Definition: &def})
+			continue
+		}
+
+		var diffs []SchemaFieldDiff
+		replace := false
+		compare := func(field, from, to string, immutable bool) {
+			if to == "" || strings.EqualFold(from, to) {
+				return
+			}
+			diffs = append(diffs, SchemaFieldDiff{Field: field, From: from, To: to})
+			replace = replace || immutable
+		}
+		compare("namespace", current.Namespace, def.Namespace, true)
+		compare("set", current.Set, def.Set, true)
+		compare("field", current.Field, def.Field, true)
+		compare("dimensions", fmt.Sprint(current.Dimensions), fmt.Sprint(def.Dimensions), true)
+		compare("distanceMetric", current.DistanceMetric, def.DistanceMetric, true)
+		if storage[def.Name] != "" {
+			compare("storage", storage[def.Name], def.Storage, true)
+		}
+		for _, key := range sortedKeys(def.Parameters) {
+			compare("parameters."+key, current.Parameters[key], def.Parameters[key], immutableParameters[key])
+		}
+		if def.Labels != nil {
+			for _, key := range sortedKeys(mergeKeys(current.Labels, def.Labels)) {
+				if current.Labels[key] != def.Labels[key] {
+					diffs = append(diffs, SchemaFieldDiff{Field: "labels." + key, From: current.Labels[key], To: def.Labels[key]})
+				}
+			}
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		action := "update"
+		if replace {
+			action = "replace"
+		}
+		plan.Changes = append(plan.Changes, SchemaChange{Action: action, Index: def.Name, Diffs: diffs, Destructive: replace, Definition: &def})
+	}
+	for _, index := range live {
+		if !wanted[index.Name] {
+			plan.Changes = append(plan.Changes, SchemaChange{Action: "drop", Index: index.Name, Destructive: true})
+		}
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool { return plan.Changes[i].Index < plan.Changes[j].Index })
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case "create":
+			plan.Creates++
+		case "update":
+			plan.Updates++
+		case "replace":
+			plan.Replaces++
+		case "drop":
+			plan.Drops++
+		}
+		plan.Destructive = plan.Destructive || change.Destructive
+	}
+	encoded, _ := json.Marshal(plan.Changes)
+	sum := sha256.Sum256(encoded)
+	plan.Hash = hex.EncodeToString(sum[:8])
+	return plan
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mergeKeys(a, b map[string]string) map[string]string {
+	merged := make(map[string]string, len(a)+len(b))
+	for key := range a {
+		merged[key] = ""
+	}
+	for key := range b {
+		merged[key] = ""
+	}
+	return merged
+}
+
+// applySchemaChange runs the asvec commands for one change.
+func applySchemaChange(ctx context.Context, change SchemaChange) error {
+	switch change.Action {
+	case "create":
+		return createIndex(ctx, *change.Definition)
+	case "update":
+		return updateIndex(ctx, change)
+	case "replace":
+		if err := dropIndex(ctx, change.Index); err != nil {
+			return err
+		}
+		if err := createIndex(ctx, *change.Definition); err != nil {
+			apiErr := asAPIError(err)
+			return &APIError{
+				Code:    apiErr.Code,
+				Message: fmt.Sprintf("index %s was dropped but could not be recreated; apply the schema again to create it", change.Index),
+				Details: apiErr.Message,
+			}
+		}
+		return nil
+	case "drop":
+		return dropIndex(ctx, change.Index)
+	}
+	return newAPIError(ErrCodeInternal, "unknown schema change %q", change.Action)
+}
+
+func createIndex(ctx context.Context, def IndexDefinition) error {
+	args := []string{"index", "create", "--index-name", def.Name, "--namespace", def.Namespace,
+		"--vector-field", def.Field, "--dimension", fmt.Sprint(def.Dimensions)}
+	if def.Set != "" {
+		args = append(args, "--set", def.Set)
+	}
+	if def.DistanceMetric != "" {
+		args = append(args, "--distance-metric", strings.ToUpper(def.DistanceMetric))
+	}
+	if def.Storage != "" {
+		args = append(args, "--storage-namespace", def.Storage)
+	}
+	args = append(args, indexOptionArgs(def)...)
+	if _, err := asvec.Exec(ctx, "index-create", args...); err != nil {
+		logger.Printf("Error executing index create command: %v", err)
+		logStderr(err)
+		return backendError("create index "+def.Name, err)
+	}
+	return nil
+}
+
+func updateIndex(ctx context.Context, change SchemaChange) error {
+	if _, err := asvec.Exec(ctx, "index-update", updateArgs(change)...); err != nil {
+		logger.Printf("Error executing index update command: %v", err)
+		logStderr(err)
+		return backendError("update index "+change.Index, err)
+	}
+	return nil
+}
+
+// updateArgs renders an update as `asvec index update` arguments. Only the
+// parameters the plan found different are passed, never the immutable ones,
+// and labels only when one of them changed, as the full set.
+func updateArgs(change SchemaChange) []string {
+	def := change.Definition
+	args := []string{"index", "update", "--index-name", change.Index}
+	labels := false
+	for _, diff := range change.Diffs {
+		if key, ok := strings.CutPrefix(diff.Field, "parameters."); ok && !immutableParameters[key] {
+			args = append(args, "--"+key, def.Parameters[key])
+		}
+		labels = labels || strings.HasPrefix(diff.Field, "labels.")
+	}
+	if labels {
+		args = append(args, labelArgs(def.Labels)...)
+	}
+	return args
+}
+
+func dropIndex(ctx context.Context, name string) error {
+	if _, err := asvec.Exec(ctx, "index-drop", "index", "drop", "--index-name", name); err != nil {
+		logger.Printf("Error executing index drop command: %v", err)
+		logStderr(err)
+		return backendError("drop index "+name, err)
+	}
+	return nil
+}
+
+// indexOptionArgs renders labels and HNSW parameters as asvec flags.
+func indexOptionArgs(def IndexDefinition) []string {
+	args := labelArgs(def.Labels)
+	for _, key := range sortedKeys(def.Parameters) {
+		args = append(args, "--"+key, def.Parameters[key])
+	}
+	return args
+}
+
+// labelArgs renders labels as an asvec flag, or nothing when there are none.
+func labelArgs(labels map[string]string) []string {
+	if len(labels) == 0 {
+		return nil
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return []string{"--index-labels", strings.Join(pairs, ",")}
+}
+
+// decodeSchemaBody reads a YAML or JSON request body into v, rejecting
+// unknown fields. JSON is read as YAML, of which it is a subset.
+func decodeSchemaBody(r *http.Request, v interface{}) error {
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxSchemaSize+1))
+	if err != nil {
+		return &APIError{Code: ErrCodeBadRequest, Message: "failed to read request body", Details: err.Error()}
+	}
+	if len(raw) > maxSchemaSize {
+		return newAPIError(ErrCodeBadRequest, "schema is larger than %d bytes", maxSchemaSize)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(v); err != nil {
+		return &APIError{Code: ErrCodeBadRequest, Message: "invalid schema", Details: err.Error()}
+	}
+	return nil
+}
+
+// getSchema exports the live indexes as a schema, in JSON or, with
+// ?format=yaml, in YAML.
+func getSchema(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling schema export request from %s", r.RemoteAddr)
+
+	indexes, err := listIndexes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	schema := Schema{Indexes: make([]IndexDefinition, len(indexes))}
+	for i, index := range indexes {
+		schema.Indexes[i] = definitionOf(index)
+	}
+
+	if r.URL.Query().Get("format") == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+		yaml.NewEncoder(w).Encode(schema)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+// planSchemaChanges diffs the schema in the body, YAML or JSON, against the
+// live cluster without changing anything.
+func planSchemaChanges(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling schema plan request from %s", r.RemoteAddr)
+
+	var schema Schema
+	if err := decodeSchemaBody(r, &schema); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := checkSchema(schema); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	live, err := listIndexes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(planSchema(schema, live))
+}
+
+// applySchema re-plans the schema and runs the changes in order, stopping at
+// the first failure. It refuses to run when the plan no longer matches the
+// reviewed hash, or when the plan is destructive and that was not allowed.
+// Once started, the changes run to the end even if the client goes away, so
+// that a replace is never left between its drop and its create.
+func applySchema(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling schema apply request from %s", r.RemoteAddr)
+
+	var req SchemaApplyRequest
+	if err := decodeSchemaBody(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := checkSchema(req.Schema); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.PlanHash == "" {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "planHash is required; run POST /api/v1/schema/plan and review the plan first"))
+		return
+	}
+	live, err := listIndexes(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	plan := planSchema(req.Schema, live)
+	if plan.Hash != req.PlanHash {
+		writeError(w, r, &APIError{
+			Code:    ErrCodeBadRequest,
+			Message: "the plan has changed since it was reviewed",
+			Details: fmt.Sprintf("reviewed plan %s, current plan %s", req.PlanHash, plan.Hash),
+		})
+		return
+	}
+	if plan.Destructive && !req.AllowDestructive {
+		writeError(w, r, newAPIError(ErrCodeBadRequest,
+			"the plan drops or replaces indexes (%d drops, %d replaces); set allowDestructive to apply it", plan.Drops, plan.Replaces))
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	result := SchemaApplyResult{Hash: plan.Hash, Results: []SchemaChangeResult{}}
+	for _, change := range plan.Changes {
+		outcome := SchemaChangeResult{Change: change, Status: "skipped"}
+		if result.Failed == 0 {
+			if err := applySchemaChange(ctx, change); err != nil {
+				outcome.Status = "failed"
+				outcome.Error = asAPIError(err)
+			} else {
+				outcome.Status = "applied"
+			}
+			logger.Printf("Schema change %s %s: %s", change.Action, change.Index, outcome.Status)
+		}
+		switch outcome.Status {
+		case "applied":
+			result.Applied++
+		case "failed":
+			result.Failed++
+		default:
+			result.Skipped++
+		}
+		result.Results = append(result.Results, outcome)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
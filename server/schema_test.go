@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func liveIndex(name string) IndexInfo {
+	return IndexInfo{
+		Name: name, Namespace: "test", Set: "docs", Field: "vec", Dimensions: 4, DistanceMetric: "COSINE",
+		Labels:     map[string]string{"team": "search"},
+		Parameters: map[string]string{"m": "16", "ef": "100", "ef-construction": "100"},
+	}
+}
+
+func withStorage(index IndexInfo, storage string) IndexInfo {
+	index.Storage = storage
+	return index
+}
+
+func TestDefinitionOfStorage(t *testing.T) {
+	for storage, want := range map[string]string{"": "", "test": "", "index-store": "index-store"} {
+		if got := definitionOf(withStorage(liveIndex("a"), storage)).Storage; got != want {
+			t.Errorf("storage %q exported as %q, want %q", storage, got, want)
+		}
+	}
+}
+
+func TestPlanSchema(t *testing.T) {
+	def := func(name string, edit func(*IndexDefinition)) IndexDefinition {
+		d := IndexDefinition{Name: name, Namespace: "test", Set: "docs", Field: "vec", Dimensions: 4}
+		if edit != nil {
+			edit(&d)
+		}
+		return d
+	}
+	tests := []struct {
+		name        string
+		schema      []IndexDefinition
+		live        []IndexInfo
+		want        []string // "action index" per change
+		diffs       []string // fields of the first change
+		destructive bool
+	}{
+		{"unchanged", []IndexDefinition{def("a", nil)}, []IndexInfo{liveIndex("a")}, nil, nil, false},
+		{"unlisted fields are not compared", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Set = "" })},
+			[]IndexInfo{liveIndex("a")}, nil, nil, false},
+		{"create", []IndexDefinition{def("b", nil)}, nil, []string{"create b"}, nil, false},
+		{"update mutable parameter", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Parameters = map[string]string{"Ef": "200"} })},
+			[]IndexInfo{liveIndex("a")}, []string{"update a"}, []string{"parameters.hnsw-ef"}, false},
+		{"update labels", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Labels = map[string]string{"team": "ml"} })},
+			[]IndexInfo{liveIndex("a")}, []string{"update a"}, []string{"labels.team"}, false},
+		{"replace on dimensions", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Dimensions = 8 })},
+			[]IndexInfo{liveIndex("a")}, []string{"replace a"}, []string{"dimensions"}, true},
+		{"replace on immutable parameter", []IndexDefinition{def("a", func(d *IndexDefinition) {
+			d.Parameters = map[string]string{"hnsw-m": "32", "hnsw-ef": "200"}
+		})}, []IndexInfo{liveIndex("a")}, []string{"replace a"}, []string{"parameters.hnsw-ef", "parameters.hnsw-m"}, true},
+		{"metric case is ignored", []IndexDefinition{def("a", func(d *IndexDefinition) { d.DistanceMetric = "cosine" })},
+			[]IndexInfo{liveIndex("a")}, nil, nil, false},
+		{"storage in the index namespace", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Storage = "test" })},
+			[]IndexInfo{withStorage(liveIndex("a"), "test")}, nil, nil, false},
+		{"storage moved", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Storage = "index-store" })},
+			[]IndexInfo{withStorage(liveIndex("a"), "test")}, []string{"replace a"}, []string{"storage"}, true},
+		{"storage not printed", []IndexDefinition{def("a", func(d *IndexDefinition) { d.Storage = "index-store" })},
+			[]IndexInfo{liveIndex("a")}, nil, nil, false},
+		{"drop", nil, []IndexInfo{liveIndex("a")}, []string{"drop a"}, nil, true},
+		{"sorted by index", []IndexDefinition{def("c", nil), def("a", func(d *IndexDefinition) { d.Field = "other" })},
+			[]IndexInfo{liveIndex("a"), liveIndex("b")}, []string{"replace a", "drop b", "create c"}, []string{"field"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planSchema(Schema{Indexes: tt.schema}, tt.live)
+			var got []string
+			for _, change := range plan.Changes {
+				got = append(got, change.Action+" "+change.Index)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+			if len(plan.Changes) > 0 {
+				var diffs []string
+				for _, diff := range plan.Changes[0].Diffs {
+					diffs = append(diffs, diff.Field)
+				}
+				if !reflect.DeepEqual(diffs, tt.diffs) {
+					t.Errorf("diffs = %q, want %q", diffs, tt.diffs)
+				}
+			}
+			if plan.Destructive != tt.destructive {
+				t.Errorf("destructive = %v", plan.Destructive)
+			}
+			if n := plan.Creates + plan.Updates + plan.Replaces + plan.Drops; n != len(plan.Changes) {
+				t.Errorf("counts add up to %d, want %d", n, len(plan.Changes))
+			}
+		})
+	}
+}
+
+func TestPlanSchemaHash(t *testing.T) {
+	schema := Schema{Indexes: []IndexDefinition{
+		{Name: "b", Namespace: "test", Field: "vec", Dimensions: 8, Labels: map[string]string{"x": "1", "y": "2"}},
+		{Name: "c", Namespace: "test", Field: "vec", Dimensions: 4},
+	}}
+	live := []IndexInfo{liveIndex("a"), liveIndex("b")}
+	hash := planSchema(schema, live).Hash
+
+	// The same plan hashes the same however the input is ordered
+	reordered := Schema{Indexes: []IndexDefinition{schema.Indexes[1], schema.Indexes[0]}}
+	for i := 0; i < 20; i++ {
+		if got := planSchema(reordered, []IndexInfo{live[1], live[0]}).Hash; got != hash {
+			t.Fatalf("hash = %s, want %s", got, hash)
+		}
+	}
+	// and differently once the cluster changes
+	if got := planSchema(schema, live[1:]).Hash; got == hash {
+		t.Error("hash did not change when an index went away")
+	}
+	if got := planSchema(Schema{Indexes: schema.Indexes[:1]}, live).Hash; got == hash {
+		t.Error("hash did not change with the schema")
+	}
+}
+
+func TestReplaceReportsDroppedIndex(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$*\" in \"index create\"*) echo 'create refused' >&2; exit 1;; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	definition := IndexDefinition{Name: "a", Namespace: "test", Field: "vec", Dimensions: 8}
+	err := applySchemaChange(context.Background(), SchemaChange{Action: "replace", Index: "a", Definition: &definition})
+	if err == nil || !strings.Contains(asAPIError(err).Message, "was dropped but could not be recreated") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestUpdatePassesChangedParameters(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "asvec"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	definition := IndexDefinition{Name: "a", Namespace: "test", Field: "vec", Dimensions: 4,
+		Labels:     map[string]string{"team": "search"},
+		Parameters: map[string]string{"hnsw-m": "16", "hnsw-ef-construction": "100", "hnsw-ef": "200"}}
+	relabelled := definition
+	relabelled.Labels = map[string]string{"team": "ml", "tier": "gold"}
+	for _, def := range []IndexDefinition{definition, relabelled} {
+		plan := planSchema(Schema{Indexes: []IndexDefinition{def}}, []IndexInfo{liveIndex("a")})
+		if len(plan.Changes) != 1 || plan.Changes[0].Action != "update" {
+			t.Fatalf("changes = %+v", plan.Changes)
+		}
+		if err := applySchemaChange(context.Background(), plan.Changes[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "index update --index-name a --hnsw-ef 200\n" +
+		"index update --index-name a --hnsw-ef 200 --index-labels team=ml,tier=gold\n"
+	if output, _ := os.ReadFile(calls); string(output) != want {
+		t.Errorf("calls = %q, want %q", output, want)
+	}
+
+	// Immutable parameters are never passed, even if a change lists them
+	change := SchemaChange{Action: "update", Index: "a", Definition: &definition,
+		Diffs: []SchemaFieldDiff{{Field: "parameters.hnsw-m", From: "16", To: "32"}}}
+	if args := updateArgs(change); !reflect.DeepEqual(args, []string{"index", "update", "--index-name", "a"}) {
+		t.Errorf("args = %q", args)
+	}
+}
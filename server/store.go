@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// readJSONFile decodes the JSON file at path into v. A missing file leaves v
+// untouched and is not an error.
+func readJSONFile(path string, v interface{}) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid JSON in %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces the file at path with v encoded as JSON. The file is
+// written to a temporary name and renamed so readers never see a partial
+// file.
+func writeJSONFile(path string, v interface{}) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// NodeDetail describes one node and how it sees the cluster.
+type NodeDetail struct {
+	NodeID    string   `json:"nodeId"`
+	Role      string   `json:"role"`
+	Endpoint  string   `json:"endpoint"`
+	Version   string   `json:"version"`
+	ClusterID string   `json:"clusterId,omitempty"`
+	Listeners []string `json:"listeners"`
+	// Seed and LoadBalancer report whether the console reaches the cluster
+	// through this node, when asvec says so.
+	Seed         *bool  `json:"seed,omitempty"`
+	LoadBalancer *bool  `json:"loadBalancer,omitempty"`
+	Uptime       string `json:"uptime,omitempty"`
+	// Peers are the node IDs this node reports as visible.
+	Peers      []string           `json:"peers"`
+	Membership NodeMembershipView `json:"membership"`
+	// Raw holds every column asvec printed for the node, by header.
+	Raw map[string]string `json:"raw"`
+}
+
+// NodeMembershipView compares a node's view of the cluster with the node
+// list. Views counts the distinct memberships the nodes report; more than
+// one means the nodes disagree, as in a split brain. Agrees is nil for a
+// node that reports no peers, whose view is unknown.
+type NodeMembershipView struct {
+	Members      []string `json:"members"`
+	Agrees       *bool    `json:"agrees,omitempty"`
+	MissingPeers []string `json:"missingPeers"`
+	UnknownPeers []string `json:"unknownPeers"`
+	Views        int      `json:"views"`
+}
+
+// listNodeDetails runs `asvec node ls --verbose` and parses every column,
+// then works out each node's membership view.
+func listNodeDetails(ctx context.Context) ([]NodeDetail, error) {
+	output, err := asvec.Run(ctx, "node-ls", cli.profile.listArgs("node", "ls", "--verbose")...)
+	if err != nil {
+		logger.Printf("Error executing node list command: %v", err)
+		logStderr(err)
+		return nil, backendError("list nodes", err)
+	}
+
+	// Parse CSV output with the columns of the detected asvec version
+	table := parseCSVTable(output)
+	columns := table.columns(cli.profile.nodes)
+	var nodes []NodeDetail
+	for _, row := range table.rows {
+		node := NodeDetail{
+			NodeID:       field(row, columns, "id"),
+			Role:         field(row, columns, "role"),
+			Endpoint:     field(row, columns, "endpoint"),
+			Version:      field(row, columns, "version"),
+			ClusterID:    field(row, columns, "cluster"),
+			Listeners:    splitList(field(row, columns, "listeners")),
+			Seed:         parseFlag(field(row, columns, "seed")),
+			LoadBalancer: parseFlag(field(row, columns, "lb")),
+			Uptime:       field(row, columns, "uptime"),
+			Peers:        parsePeers(field(row, columns, "peers")),
+			Raw:          map[string]string{},
+		}
+		if node.NodeID == "" {
+			continue
+		}
+		for i, header := range table.headers {
+			if header = strings.TrimSpace(header); header != "" && i < len(row) {
+				node.Raw[header] = strings.TrimSpace(row[i])
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, newAPIError(ErrCodeBackend, "asvec returned no nodes")
+	}
+
+	computeMembership(nodes)
+	return nodes, nil
+}
+
+// computeMembership fills in each node's membership view. A node's members
+// are itself plus its peers; nodes that report no peers at all are left out
+// of the comparison, since asvec does not know their view.
+func computeMembership(nodes []NodeDetail) {
+	listed := map[string]bool{}
+	known := map[string]bool{}
+	for _, node := range nodes {
+		listed[node.NodeID] = true
+		if len(node.Peers) > 0 || len(nodes) == 1 {
+			known[node.NodeID] = true
+		}
+	}
+
+	views := map[string]bool{}
+	for i := range nodes {
+		node := &nodes[i]
+		members := map[string]bool{node.NodeID: true}
+		for _, peer := range node.Peers {
+			members[peer] = true
+		}
+		view := NodeMembershipView{Members: sortedSet(members), MissingPeers: []string{}, UnknownPeers: []string{}}
+		node.Membership = view
+		if !known[node.NodeID] {
+			continue
+		}
+		for id := range listed {
+			// Nodes of unknown view are left out on both sides
+			if !members[id] && known[id] {
+				view.MissingPeers = append(view.MissingPeers, id)
+			}
+		}
+		for id := range members {
+			if !listed[id] {
+				view.UnknownPeers = append(view.UnknownPeers, id)
+			}
+		}
+		sort.Strings(view.MissingPeers)
+		sort.Strings(view.UnknownPeers)
+		agrees := len(view.MissingPeers) == 0 && len(view.UnknownPeers) == 0
+		view.Agrees = &agrees
+		views[strings.Join(view.Members, ",")] = true
+		node.Membership = view
+	}
+	for i := range nodes {
+		nodes[i].Membership.Views = len(views)
+	}
+}
+
+func sortedSet(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for item := range set {
+		list = append(list, item)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// splitList splits a column holding several values, however asvec
+// delimits them.
+func splitList(value string) []string {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "map")
+	items := strings.FieldsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",;[]{}", r)
+	})
+	if items == nil {
+		items = []string{}
+	}
+	return items
+}
+
+// parsePeers reads node IDs from a peers column. Entries may carry the
+// peer's endpoint, as in "139637976803089:10.0.0.2:5000".
+func parsePeers(value string) []string {
+	var peers []string
+	for _, item := range splitList(value) {
+		id, _, _ := strings.Cut(item, ":")
+		id, _, _ = strings.Cut(id, "=")
+		if id != "" && strings.IndexFunc(id, func(r rune) bool { return r < '0' || r > '9' }) < 0 {
+			peers = append(peers, id)
+		}
+	}
+	if peers == nil {
+		peers = []string{}
+	}
+	return peers
+}
+
+// parseFlag reads a yes/no column; it returns nil when the column is absent
+// or unrecognised.
+func parseFlag(value string) *bool {
+	var flag bool
+	switch strings.ToLower(value) {
+	case "true", "yes", "y", "1", "*", "x":
+		flag = true
+	case "false", "no", "n", "0", "-":
+		flag = false
+	default:
+		return nil
+	}
+	return &flag
+}
+
+func getNode(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	id := r.PathValue("id")
+	logger.Printf("Handling node %q request from %s", id, r.RemoteAddr)
+
+	nodes, err := listNodeDetails(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, node := range nodes {
+		if node.NodeID == id {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(node)
+			return
+		}
+	}
+	writeError(w, r, newAPIError(ErrCodeNotFound, "node %q not found", id))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeMembership(t *testing.T) {
+	node := func(id string, peers ...string) NodeDetail {
+		if peers == nil {
+			peers = []string{}
+		}
+		return NodeDetail{NodeID: id, Peers: peers}
+	}
+	type view struct {
+		agrees  string // "yes", "no" or "" for unknown
+		missing []string
+		unknown []string
+	}
+	tests := []struct {
+		name  string
+		nodes []NodeDetail
+		want  map[string]view
+		views int
+	}{
+		{"no peers column", []NodeDetail{node("1"), node("2"), node("3")},
+			map[string]view{"1": {}, "2": {}, "3": {}}, 0},
+		{"single node", []NodeDetail{node("1")},
+			map[string]view{"1": {agrees: "yes"}}, 1},
+		{"all agree", []NodeDetail{node("1", "2", "3"), node("2", "1", "3"), node("3", "1", "2")},
+			map[string]view{"1": {agrees: "yes"}, "2": {agrees: "yes"}, "3": {agrees: "yes"}}, 1},
+		{"some peers unknown", []NodeDetail{node("1", "2"), node("2", "1"), node("3")},
+			map[string]view{"1": {agrees: "yes"}, "2": {agrees: "yes"}, "3": {}}, 1},
+		{"split brain", []NodeDetail{node("1", "2"), node("2", "1"), node("3", "4"), node("4", "3")},
+			map[string]view{
+				"1": {"no", []string{"3", "4"}, nil}, "2": {"no", []string{"3", "4"}, nil},
+				"3": {"no", []string{"1", "2"}, nil}, "4": {"no", []string{"1", "2"}, nil},
+			}, 2},
+		{"peer not listed", []NodeDetail{node("1", "2", "9"), node("2", "1")},
+			map[string]view{"1": {"no", nil, []string{"9"}}, "2": {agrees: "yes"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			computeMembership(tt.nodes)
+			for _, n := range tt.nodes {
+				m, want := n.Membership, tt.want[n.NodeID]
+				agrees := ""
+				if m.Agrees != nil {
+					agrees = map[bool]string{true: "yes", false: "no"}[*m.Agrees]
+				}
+				if want.missing == nil {
+					want.missing = []string{}
+				}
+				if want.unknown == nil {
+					want.unknown = []string{}
+				}
+				if agrees != want.agrees || !reflect.DeepEqual(m.MissingPeers, want.missing) || !reflect.DeepEqual(m.UnknownPeers, want.unknown) {
+					t.Errorf("node %s: membership = %+v, agrees %q", n.NodeID, m, agrees)
+				}
+				if m.Views != tt.views {
+					t.Errorf("node %s: views = %d, want %d", n.NodeID, m.Views, tt.views)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	tests := map[string][]string{
+		"":                                  {},
+		"139637976803089":                   {"139637976803089"},
+		"[1:10.0.0.1:5000 2:10.0.0.2:5000]": {"1", "2"},
+		"map[1=10.0.0.1,2=10.0.0.2]":        {"1", "2"},
+		"1; node-b":                         {"1"},
+	}
+	for value, want := range tests {
+		if got := parsePeers(value); !reflect.DeepEqual(got, want) {
+			t.Errorf("parsePeers(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionPattern finds a dotted version number in command output.
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// VersionGroup is a set of nodes running the same version with the same
+// role.
+type VersionGroup struct {
+	Version string   `json:"version"`
+	Role    string   `json:"role"`
+	Nodes   []string `json:"nodes"`
+	// SupportedByCLI is false when the installed asvec cannot manage this
+	// version.
+	SupportedByCLI bool `json:"supportedByCli"`
+}
+
+// UpgradeStep is one node of the rolling-upgrade order.
+type UpgradeStep struct {
+	Order   int    `json:"order"`
+	NodeID  string `json:"nodeId"`
+	Role    string `json:"role"`
+	Version string `json:"version"`
+	Reason  string `json:"reason"`
+}
+
+// UpgradeTransition is a version change seen between two polls.
+type UpgradeTransition struct {
+	NodeID string    `json:"nodeId"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+}
+
+// UpgradeProgress is the state of an upgrade, worked out from the version
+// changes seen between successive polls of the node list. State is "idle"
+// until a node is seen to change version, "in_progress" from then on while
+// nodes are below the newest version or missing, and "completed" once every
+// node runs it. Target is the newest version seen during the upgrade.
+type UpgradeProgress struct {
+	State       string              `json:"state"`
+	From        string              `json:"from,omitempty"`
+	Target      string              `json:"target,omitempty"`
+	StartedAt   *time.Time          `json:"startedAt,omitempty"`
+	CompletedAt *time.Time          `json:"completedAt,omitempty"`
+	Upgraded    []string            `json:"upgraded"`
+	Remaining   []string            `json:"remaining"`
+	Missing     []string            `json:"missing"`
+	Transitions []UpgradeTransition `json:"transitions"`
+}
+
+// UpgradeReport describes the versions in a cluster and how to upgrade it.
+type UpgradeReport struct {
+	CLIVersion string         `json:"cliVersion"`
+	Target     string         `json:"target"`
+	Mixed      bool           `json:"mixed"`
+	Groups     []VersionGroup `json:"groups"`
+	// Unsupported lists versions the installed asvec does not support.
+	Unsupported []string        `json:"unsupported"`
+	Order       []UpgradeStep   `json:"order"`
+	Progress    UpgradeProgress `json:"progress"`
+}
+
+// parseVersion extracts the numeric parts of a version such as "1.2.0" or
+// "asvec version 3.0.0".
+func parseVersion(version string) []int {
+	match := versionPattern.FindString(version)
+	if match == "" {
+		return nil
+	}
+	var parts []int
+	for _, part := range strings.Split(match, ".") {
+		n, _ := strconv.Atoi(part)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// compareVersions orders two versions numerically, treating missing parts
+// as zero.
+func compareVersions(a, b string) int {
+	pa, pb := parseVersion(a), parseVersion(b)
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// cliSupports reports whether an asvec version can manage a server version,
+// according to the version matrix. Versions outside the matrix are assumed
+// to support everything.
+func cliSupports(cliVersion, serverVersion string) bool {
+	profile := cliProfileFor(cliVersion)
+	if profile == nil || len(parseVersion(serverVersion)) == 0 {
+		return true
+	}
+	support := profile.support
+	return compareVersions(serverVersion, support.ServerMin) >= 0 && compareVersions(serverVersion, support.ServerBelow) < 0
+}
+
+// installedCLIVersion returns the version of the installed asvec.
+func installedCLIVersion(ctx context.Context) (string, error) {
+	output, err := asvec.Run(ctx, "version", "--version")
+	if err != nil {
+		logStderr(err)
+		return "", backendError("get the asvec version", err)
+	}
+	version := versionPattern.FindString(string(output))
+	if version == "" {
+		return "", newAPIError(ErrCodeBackend, "cannot read the asvec version from %q", strings.TrimSpace(string(output)))
+	}
+	return version, nil
+}
+
+// upgradeOrder lists the nodes not yet on target in a safe rolling order:
+// nodes without the indexer role first, so that indexing continues, then
+// indexers, and the seed nodes the console connects through last.
+func upgradeOrder(nodes []NodeDetail, target string) []UpgradeStep {
+	rank := func(node NodeDetail) (int, string) {
+		seed := node.Seed != nil && *node.Seed
+		indexer := strings.Contains(strings.ToUpper(node.Role), "INDEX")
+		switch {
+		case seed:
+			return 2, "seed node the console connects through; upgrade last"
+		case indexer:
+			return 1, "indexer; upgrade after query nodes, one at a time"
+		}
+		return 0, "no indexer role; safe to upgrade first"
+	}
+
+	pending := []NodeDetail{}
+	for _, node := range nodes {
+		if compareVersions(node.Version, target) < 0 {
+			pending = append(pending, node)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		ri, _ := rank(pending[i])
+		rj, _ := rank(pending[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return pending[i].NodeID < pending[j].NodeID
+	})
+
+	steps := make([]UpgradeStep, len(pending))
+	for i, node := range pending {
+		_, reason := rank(node)
+		steps[i] = UpgradeStep{Order: i + 1, NodeID: node.NodeID, Role: node.Role, Version: node.Version, Reason: reason}
+	}
+	return steps
+}
+
+// upgrades tracks upgrades across polls of the upgrade report.
+var upgrades *upgradeTracker
+
+// upgradeTracker remembers the node versions seen by the previous poll and
+// the upgrade they show, saved to upgrade-state.json.
+type upgradeTracker struct {
+	path string
+
+	mu    sync.Mutex
+	saved upgradeState
+}
+
+type upgradeState struct {
+	Progress UpgradeProgress   `json:"progress"`
+	Versions map[string]string `json:"versions"`
+}
+
+func newUpgradeTracker(dir string) *upgradeTracker {
+	t := &upgradeTracker{path: filepath.Join(dir, "upgrade-state.json")}
+	if err := readJSONFile(t.path, &t.saved); err != nil {
+		logger.Printf("Error loading upgrade state: %v", err)
+	}
+	return t
+}
+
+// Observe records a poll of the node list and returns the progress of the
+// upgrade it shows. Only version changes between polls start an upgrade; a
+// cluster first seen with mixed versions stays idle.
+func (t *upgradeTracker) Observe(nodes []NodeDetail, now time.Time) UpgradeProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := map[string]string{}
+	highest := ""
+	for _, node := range nodes {
+		current[node.NodeID] = node.Version
+		if compareVersions(node.Version, highest) > 0 {
+			highest = node.Version
+		}
+	}
+
+	var changed []UpgradeTransition
+	from := ""
+	for _, id := range sortedKeys(current) {
+		if previous, ok := t.saved.Versions[id]; ok && previous != current[id] {
+			changed = append(changed, UpgradeTransition{NodeID: id, From: previous, To: current[id], At: now})
+			if from == "" || compareVersions(previous, from) < 0 {
+				from = previous
+			}
+		}
+	}
+	missing := []string{}
+	for id := range t.saved.Versions {
+		if _, ok := current[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+
+	state := t.saved.Progress
+	switch {
+	case len(changed) > 0 && state.State != "in_progress":
+		state = UpgradeProgress{State: "in_progress", From: from, Target: highest, StartedAt: &now, Transitions: changed}
+	case state.State == "in_progress":
+		state.Transitions = append(state.Transitions, changed...)
+		if compareVersions(highest, state.Target) > 0 {
+			state.Target = highest
+		}
+	case state.State == "":
+		state.State = "idle"
+	}
+	if state.Transitions == nil {
+		state.Transitions = []UpgradeTransition{}
+	}
+
+	target := state.Target
+	if state.State != "in_progress" {
+		target = highest
+	}
+	state.Upgraded, state.Remaining = []string{}, []string{}
+	for _, id := range sortedKeys(current) {
+		if compareVersions(current[id], target) >= 0 {
+			state.Upgraded = append(state.Upgraded, id)
+		} else {
+			state.Remaining = append(state.Remaining, id)
+		}
+	}
+
+	if state.State == "in_progress" && len(state.Remaining) == 0 && len(missing) == 0 {
+		state.State, state.CompletedAt = "completed", &now
+	}
+	// Nodes that dropped out, usually to restart on a new version, are
+	// remembered until they come back, so that the change is seen.
+	state.Missing = missing
+	for _, id := range missing {
+		current[id] = t.saved.Versions[id]
+	}
+	t.saved = upgradeState{Progress: state, Versions: current}
+	if err := writeJSONFile(t.path, t.saved); err != nil {
+		logger.Printf("Error saving upgrade state: %v", err)
+	}
+	return state
+}
+
+// buildUpgradeReport groups nodes by version and role and plans an upgrade
+// to target, defaulting to the newest version in the cluster.
+func buildUpgradeReport(nodes []NodeDetail, cliVersion, target string) UpgradeReport {
+	if target == "" {
+		for _, node := range nodes {
+			if target == "" || compareVersions(node.Version, target) > 0 {
+				target = node.Version
+			}
+		}
+	}
+	report := UpgradeReport{CLIVersion: cliVersion, Target: target, Groups: []VersionGroup{}, Unsupported: []string{}}
+
+	groups := map[string]*VersionGroup{}
+	versions := map[string]bool{}
+	for _, node := range nodes {
+		versions[node.Version] = true
+		key := node.Version + "\x00" + node.Role
+		group, ok := groups[key]
+		if !ok {
+			group = &VersionGroup{Version: node.Version, Role: node.Role, SupportedByCLI: cliSupports(cliVersion, node.Version)}
+			groups[key] = group
+		}
+		group.Nodes = append(group.Nodes, node.NodeID)
+	}
+	for _, group := range groups {
+		sort.Strings(group.Nodes)
+		report.Groups = append(report.Groups, *group)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if c := compareVersions(report.Groups[i].Version, report.Groups[j].Version); c != 0 {
+			return c < 0
+		}
+		return report.Groups[i].Role < report.Groups[j].Role
+	})
+	for version := range versions {
+		if !cliSupports(cliVersion, version) {
+			report.Unsupported = append(report.Unsupported, version)
+		}
+	}
+	if target != "" && !versions[target] && !cliSupports(cliVersion, target) {
+		report.Unsupported = append(report.Unsupported, target)
+	}
+	sort.Slice(report.Unsupported, func(i, j int) bool { return compareVersions(report.Unsupported[i], report.Unsupported[j]) < 0 })
+	report.Mixed = len(versions) > 1
+	report.Order = upgradeOrder(nodes, target)
+	return report
+}
+
+// getUpgradeReport reports the versions in the cluster, the rolling-upgrade
+// order to ?target= (the newest version present by default) and the
+// progress of an upgrade seen across polls. The target only plans the
+// order; progress follows the versions the nodes actually run.
+func getUpgradeReport(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w, r)
+	logger.Printf("Handling upgrade report request from %s", r.RemoteAddr)
+
+	target := r.URL.Query().Get("target")
+	if target != "" && len(parseVersion(target)) == 0 {
+		writeError(w, r, newAPIError(ErrCodeBadRequest, "target %q is not a version", target))
+		return
+	}
+	nodes, err := listNodeDetails(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cliVersion, err := installedCLIVersion(r.Context())
+	if err != nil {
+		logger.Printf("Upgrade report without asvec version: %v", err)
+	}
+
+	report := buildUpgradeReport(nodes, cliVersion, target)
+	report.Progress = upgrades.Observe(nodes, time.Now().UTC())
+	if len(report.Unsupported) > 0 {
+		logger.Printf("asvec %s does not support AVS %s", cliVersion, strings.Join(report.Unsupported, ", "))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
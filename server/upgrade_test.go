@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func versions(pairs ...string) []NodeDetail {
+	var nodes []NodeDetail
+	for i := 0; i+1 < len(pairs); i += 2 {
+		nodes = append(nodes, NodeDetail{NodeID: pairs[i], Version: pairs[i+1]})
+	}
+	return nodes
+}
+
+func TestUpgradeObserve(t *testing.T) {
+	dir := t.TempDir()
+	tracker := newUpgradeTracker(dir)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	poll := func(nodes []NodeDetail) UpgradeProgress {
+		now = now.Add(time.Minute)
+		return tracker.Observe(nodes, now)
+	}
+
+	steps := []struct {
+		name      string
+		nodes     []NodeDetail
+		state     string
+		remaining []string
+		missing   []string
+	}{
+		{"first poll", versions("a", "1.0.0", "b", "1.0.0", "c", "1.0.0"), "idle", []string{}, []string{}},
+		{"no change", versions("a", "1.0.0", "b", "1.0.0", "c", "1.0.0"), "idle", []string{}, []string{}},
+		{"node restarts", versions("b", "1.0.0", "c", "1.0.0"), "idle", []string{}, []string{"a"}},
+		{"node back upgraded", versions("a", "1.1.0", "b", "1.0.0", "c", "1.0.0"), "in_progress", []string{"b", "c"}, []string{}},
+		{"second node", versions("a", "1.1.0", "b", "1.1.0", "c", "1.0.0"), "in_progress", []string{"c"}, []string{}},
+		{"last node restarts", versions("a", "1.1.0", "b", "1.1.0"), "in_progress", []string{}, []string{"c"}},
+		{"last node back", versions("a", "1.1.0", "b", "1.1.0", "c", "1.1.0"), "completed", []string{}, []string{}},
+		{"stays completed", versions("a", "1.1.0", "b", "1.1.0", "c", "1.1.0"), "completed", []string{}, []string{}},
+	}
+	var progress UpgradeProgress
+	for _, step := range steps {
+		progress = poll(step.nodes)
+		if progress.State != step.state || !reflect.DeepEqual(progress.Remaining, step.remaining) || !reflect.DeepEqual(progress.Missing, step.missing) {
+			t.Fatalf("%s: progress = %+v", step.name, progress)
+		}
+	}
+	if progress.From != "1.0.0" || progress.Target != "1.1.0" || len(progress.Transitions) != 3 || progress.CompletedAt == nil {
+		t.Errorf("completed upgrade = %+v", progress)
+	}
+
+	// The state survives a restart of the console
+	if got := newUpgradeTracker(dir).saved.Progress; got.State != "completed" || len(got.Transitions) != 3 {
+		t.Errorf("reloaded progress = %+v", got)
+	}
+}
+
+func TestUpgradeObserveNeedsAVersionChange(t *testing.T) {
+	tracker := newUpgradeTracker(t.TempDir())
+	now := time.Now()
+
+	// A cluster first seen with mixed versions has no upgrade to follow
+	mixed := versions("a", "1.0.0", "b", "1.1.0")
+	if progress := tracker.Observe(mixed, now); progress.State != "idle" || !reflect.DeepEqual(progress.Remaining, []string{"a"}) {
+		t.Errorf("progress = %+v", progress)
+	}
+	if progress := tracker.Observe(mixed, now); progress.State != "idle" {
+		t.Errorf("progress = %+v", progress)
+	}
+}
+
+func TestUpgradeOrder(t *testing.T) {
+	yes, no := true, false
+	nodes := []NodeDetail{
+		{NodeID: "seed", Role: "INDEXER", Version: "1.0.0", Seed: &yes},
+		{NodeID: "idx2", Role: "INDEXER", Version: "1.0.0", Seed: &no},
+		{NodeID: "idx1", Role: "indexer, query", Version: "1.0.0"},
+		{NodeID: "q", Role: "QUERY", Version: "1.0.0"},
+		{NodeID: "done", Role: "QUERY", Version: "1.1.0"},
+		{NodeID: "newer", Role: "QUERY", Version: "1.2.0"},
+	}
+	var got []string
+	for i, step := range upgradeOrder(nodes, "1.1.0") {
+		if step.Order != i+1 || step.Reason == "" {
+			t.Errorf("step = %+v", step)
+		}
+		got = append(got, step.NodeID)
+	}
+	if want := []string{"q", "idx1", "idx2", "seed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %q, want %q", got, want)
+	}
+	if steps := upgradeOrder(nodes, "1.0.0"); len(steps) != 0 {
+		t.Errorf("steps = %+v", steps)
+	}
+}